@@ -0,0 +1,67 @@
+package sub
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Jittered exponential backoff between retries of failed requests.
+type Backoff struct {
+	// The delay before the first retry. DefaultBackoff.Initial is used when
+	// zero.
+	Initial time.Duration
+
+	// The longest delay between retries, before the jitter. DefaultBackoff.Max
+	// is used when zero.
+	Max time.Duration
+
+	// The factor the delay grows by after each retry.
+	// DefaultBackoff.Multiplier is used when less than 1.
+	Multiplier float64
+
+	// The fraction of each delay which is randomized. With a jitter of 0.2, a
+	// delay of 10s becomes anything from 8s to 12s.
+	Jitter float64
+}
+
+// The backoff used when none is configured.
+var DefaultBackoff = Backoff{
+	Initial:    5 * time.Second,
+	Max:        10 * time.Minute,
+	Multiplier: 2,
+	Jitter:     0.2,
+}
+
+// Returns the delay before retry number attempt, starting at 0.
+func (b *Backoff) delay(attempt int) time.Duration {
+	initial := b.Initial
+	if initial <= 0 {
+		initial = DefaultBackoff.Initial
+	}
+
+	multiplier := b.Multiplier
+	if multiplier < 1 {
+		multiplier = DefaultBackoff.Multiplier
+	}
+
+	limit := b.Max
+	if limit <= 0 {
+		limit = DefaultBackoff.Max
+	}
+
+	delay := float64(initial) * math.Pow(multiplier, float64(attempt))
+	if delay > float64(limit) {
+		delay = float64(limit)
+	}
+
+	// spread retries of many clients out
+	delay += delay * b.Jitter * (2*rand.Float64() - 1)
+
+	if delay >= math.MaxInt64 {
+		// longer than a duration can be
+		return math.MaxInt64
+	}
+
+	return time.Duration(delay)
+}
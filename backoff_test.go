@@ -0,0 +1,51 @@
+package sub
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	backoff := &Backoff{
+		Initial:    time.Second,
+		Max:        5 * time.Second,
+		Multiplier: 2,
+		Jitter:     0.5,
+	}
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second,
+		5 * time.Second, 5 * time.Second}
+
+	for attempt, base := range expected {
+		delay := backoff.delay(attempt)
+		if delay < base/2 || delay > base*3/2 {
+			t.Error("Attempt", attempt, "delay", delay, "not within jitter of", base)
+		}
+	}
+}
+
+func TestBackoffDefaults(t *testing.T) {
+	backoff := &Backoff{}
+
+	for attempt, expected := range []time.Duration{5 * time.Second, 10 * time.Second} {
+		delay := backoff.delay(attempt)
+		if delay != expected {
+			t.Error("Attempt", attempt, "got", delay, "expected", expected)
+		}
+	}
+}
+
+func TestBackoffLimits(t *testing.T) {
+	unlimited := &Backoff{Initial: time.Second}
+	if delay := unlimited.delay(100); delay != DefaultBackoff.Max {
+		t.Error("Expected the default limit, got", delay)
+	}
+
+	huge := &Backoff{Initial: time.Second, Max: math.MaxInt64, Jitter: 1}
+	for attempt := 0; attempt < 100; attempt++ {
+		if delay := huge.delay(attempt); delay < 0 {
+			t.Fatal("Attempt", attempt, "overflowed to", delay)
+		}
+	}
+}
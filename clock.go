@@ -0,0 +1,42 @@
+package sub
+
+import "time"
+
+// A source of time for lease math and renewal scheduling. Replacing it allows
+// leases and renewals to be driven deterministically in tests.
+type Clock interface {
+	Now() time.Time
+
+	// Calls f once d has passed. SystemClock calls f in its own goroutine,
+	// other clocks may call it from the goroutine advancing time, so f must
+	// not wait on the clock itself.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// A pending call scheduled with Clock.AfterFunc.
+type Timer interface {
+	// Prevents the call from happening. Returns false if the call already
+	// happened or was stopped.
+	Stop() bool
+}
+
+// The Clock backed by the time package.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+func (s *Sub) clock() Clock {
+	if s.Clock != nil {
+		return s.Clock
+	}
+
+	return SystemClock
+}
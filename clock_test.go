@@ -0,0 +1,113 @@
+package sub
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// A clock which only moves when advanced. Timers fire on the goroutine calling
+// Advance.
+type fakeClock struct {
+	lock   sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock *fakeClock
+	at    time.Time
+	f     func()
+	done  bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	timer := &fakeTimer{clock: c, at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, timer)
+	return timer
+}
+
+// Moves the clock forward by d, firing due timers in order.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	target := c.now.Add(d)
+
+	for {
+		var next *fakeTimer
+		for _, timer := range c.timers {
+			if !timer.done && !timer.at.After(target) &&
+				(next == nil || timer.at.Before(next.at)) {
+				next = timer
+			}
+		}
+
+		if next == nil {
+			break
+		}
+
+		next.done = true
+		if next.at.After(c.now) {
+			c.now = next.at
+		}
+
+		c.lock.Unlock()
+		next.f()
+		c.lock.Lock()
+	}
+
+	c.now = target
+	c.lock.Unlock()
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+
+	wasPending := !t.done
+	t.done = true
+	return wasPending
+}
+
+func TestFakeClock(t *testing.T) {
+	clock := newFakeClock()
+	start := clock.Now()
+
+	fired := []time.Duration{}
+	clock.AfterFunc(2*time.Hour, func() {
+		fired = append(fired, clock.Now().Sub(start))
+	})
+	clock.AfterFunc(time.Hour, func() {
+		fired = append(fired, clock.Now().Sub(start))
+	})
+	stopped := clock.AfterFunc(90*time.Minute, func() {
+		t.Error("A stopped timer fired")
+	})
+
+	if !stopped.Stop() {
+		t.Error("Stopping a pending timer should succeed")
+	}
+
+	clock.Advance(3 * time.Hour)
+
+	if len(fired) != 2 || fired[0] != time.Hour || fired[1] != 2*time.Hour {
+		t.Error("Timers fired at", fired)
+	}
+
+	if clock.Now().Sub(start) != 3*time.Hour {
+		t.Error("The clock didn't advance")
+	}
+}
@@ -0,0 +1,133 @@
+package sub
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// The default of Deduplicator.Size.
+const defaultDedupSize = 1000
+
+// Remembers the keys of recently delivered entries and messages so repeated
+// deliveries can be dropped. Only the last Size keys are remembered. It is safe
+// for concurrent use.
+type Deduplicator struct {
+	// The number of keys remembered. The oldest keys are forgotten first. 1000
+	// is used when zero.
+	Size int
+
+	// When set, entries which changed since they were seen are new too. An
+	// entry changed when its updated time or, if it has none, its raw content
+	// is different.
+	IncludeChanged bool
+
+	// When set, only the entries of feeds are deduplicated. Other messages are
+	// always delivered.
+	EntriesOnly bool
+
+	lock sync.Mutex
+
+	// the remembered keys, oldest first
+	keys []string
+	seen map[string]bool
+}
+
+// Returns a deduplicator remembering size keys.
+func NewDeduplicator(size int) *Deduplicator {
+	return &Deduplicator{Size: size}
+}
+
+// Remembers key. Returns true if it wasn't remembered before.
+func (d *Deduplicator) Add(key string) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return d.addLocked(key)
+}
+
+// Remembers key. The lock must be held.
+func (d *Deduplicator) addLocked(key string) bool {
+	if d.seen == nil {
+		d.seen = map[string]bool{}
+	}
+
+	if d.seen[key] {
+		return false
+	}
+
+	d.seen[key] = true
+	d.keys = append(d.keys, key)
+
+	size := d.Size
+	if size <= 0 {
+		size = defaultDedupSize
+	}
+
+	// forget the oldest keys
+	for len(d.keys) > size {
+		delete(d.seen, d.keys[0])
+		d.keys = d.keys[1:]
+	}
+
+	return true
+}
+
+// Returns the remembered keys, oldest first. They can be persisted and
+// remembered again with Load.
+func (d *Deduplicator) Keys() []string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return append([]string(nil), d.keys...)
+}
+
+// Remembers keys returned by Keys, in addition to the keys already
+// remembered.
+func (d *Deduplicator) Load(keys []string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for _, key := range keys {
+		d.addLocked(key)
+	}
+}
+
+// Returns the key of an entry: its id or, when it has none, a hash of the raw
+// entry. With IncludeChanged, the version of the entry is part of the key.
+func (d *Deduplicator) entryKey(entry *Entry) string {
+	if entry.ID == "" {
+		return bodyKey(entry.Raw)
+	}
+
+	key := "id:" + entry.ID
+	if !d.IncludeChanged {
+		return key
+	}
+
+	if !entry.Updated.IsZero() {
+		return key + "@" + entry.Updated.UTC().Format(time.RFC3339Nano)
+	}
+
+	return key + "@" + bodyKey(entry.Raw)
+}
+
+// Returns the key of a message which isn't a feed.
+func bodyKey(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Returns the entries which weren't delivered before, or changed since with
+// IncludeChanged, and remembers them.
+func (d *Deduplicator) newEntries(entries []Entry) []Entry {
+	fresh := []Entry{}
+	for _, entry := range entries {
+		if d.Add(d.entryKey(&entry)) {
+			fresh = append(fresh, entry)
+		}
+	}
+
+	return fresh
+}
@@ -0,0 +1,170 @@
+package sub
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDeduplicatorWindow(t *testing.T) {
+	d := NewDeduplicator(2)
+
+	for _, key := range []string{"a", "b"} {
+		if !d.Add(key) {
+			t.Error("Expected", key, "to be new")
+		}
+	}
+
+	if d.Add("a") {
+		t.Error("Expected a to be remembered")
+	}
+
+	// forgets a
+	d.Add("c")
+	if !reflect.DeepEqual(d.Keys(), []string{"b", "c"}) {
+		t.Error("Unexpected keys", d.Keys())
+	}
+
+	if !d.Add("a") {
+		t.Error("Expected a to be forgotten")
+	}
+
+	loaded := NewDeduplicator(0)
+	loaded.Load(d.Keys())
+	if loaded.Add("c") || !loaded.Add("b") {
+		t.Error("Unexpected keys after loading", loaded.Keys())
+	}
+}
+
+func TestDedupMessages(t *testing.T) {
+	path, cleanup := tempStorePath(t)
+	defer cleanup()
+
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []string
+	var messages int
+	s := &Sub{
+		AllowUnsigned: true,
+		Dedup:         NewDeduplicator(0),
+		Store:         store,
+		StoreKey:      "feed",
+		OnEntries: func(r *http.Request, got []Entry) {
+			for _, entry := range got {
+				entries = append(entries, entry.ID)
+			}
+		},
+		OnMessage: func(r *http.Request, body []byte) {
+			messages++
+		},
+	}
+
+	deliver := func(contentType, body string) {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		s.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	deliver("application/atom+xml", atomFeed)
+
+	// a retry and the whole feed with a new entry
+	deliver("application/atom+xml", atomFeed)
+	deliver("application/atom+xml", strings.Replace(atomFeed, "<entry>", `<entry>
+    <id>urn:entry:3</id>
+  </entry>
+  <entry>`, 1))
+
+	expected := []string{"urn:entry:1", "urn:entry:2", "urn:entry:3"}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Error("Expected", expected, "got", entries)
+	}
+
+	deliver("text/plain", "hello")
+	deliver("text/plain", "hello")
+	if messages != 1 {
+		t.Error("Expected the repeated message to be dropped, got", messages)
+	}
+
+	// the window is restored from the store
+	record, err := store.Get("feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := &Sub{Dedup: NewDeduplicator(0)}
+	err = restored.Restore(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if restored.Dedup.Add("id:urn:entry:3") {
+		t.Error("Expected the seen entries to be restored")
+	}
+}
+
+func TestDedupIncludeChanged(t *testing.T) {
+	d := NewDeduplicator(0)
+	d.IncludeChanged = true
+
+	entries, err := ParseFeed("application/atom+xml", []byte(atomFeed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(d.newEntries(entries)) != 2 {
+		t.Error("Expected every entry to be new")
+	}
+
+	// the first entry is updated and the second is edited
+	changed := strings.Replace(atomFeed, "2017-06-02", "2017-06-03", 1)
+	changed = strings.Replace(changed, "Second", "Second, edited", 1)
+	entries, err = ParseFeed("application/atom+xml", []byte(changed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fresh := d.newEntries(entries); len(fresh) != 2 {
+		t.Error("Expected the changed entries, got", len(fresh))
+	}
+
+	if fresh := d.newEntries(entries); len(fresh) != 0 {
+		t.Error("Expected no changes, got", len(fresh))
+	}
+}
+
+func TestDedupEntriesOnly(t *testing.T) {
+	var entries, messages int
+	s := &Sub{
+		AllowUnsigned: true,
+		Dedup:         &Deduplicator{EntriesOnly: true},
+		OnEntries: func(r *http.Request, got []Entry) {
+			entries += len(got)
+		},
+		OnMessage: func(r *http.Request, body []byte) {
+			messages++
+		},
+	}
+
+	deliver := func(contentType, body string) {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		s.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	deliver("application/atom+xml", atomFeed)
+	deliver("application/atom+xml", atomFeed)
+	if entries != 2 {
+		t.Error("Expected the repeated entries to be dropped, got", entries)
+	}
+
+	deliver("text/plain", "hello")
+	deliver("text/plain", "hello")
+	if messages != 2 {
+		t.Error("Expected every message to be delivered, got", messages)
+	}
+}
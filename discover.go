@@ -1,68 +1,292 @@
 package sub
 
 import (
+	"bytes"
+	"context"
 	"encoding/xml"
 	"io/ioutil"
 	"mime"
+	"net/http"
 	"net/url"
 	"strings"
 )
 
-// Discovers a hub from s.Topic using s.Client.
-func (s *Sub) Discover() error {
-	// get the topic
-	resp, err := s.Client.Get(s.Topic.String())
-	if err != nil {
-		return err
+// The links advertised by a topic.
+type Discovery struct {
+	// Every hub advertised by the topic in the order they were advertised.
+	Hubs []*url.URL
+
+	// The canonical url of the topic. This is the url advertised with
+	// rel="self" or, if there is none, the url of the topic after following
+	// redirects.
+	Self *url.URL
+}
+
+// Adds a hub to the discovery unless it is already known.
+func (d *Discovery) addHub(hub *url.URL) {
+	for _, known := range d.Hubs {
+		if known.String() == hub.String() {
+			return
+		}
 	}
 
-	// TODO: try to get links from headers
-	body, err := ioutil.ReadAll(resp.Body)
+	d.Hubs = append(d.Hubs, hub)
+}
+
+// Discovers the hubs and canonical url of topic using client. Links advertised
+// in the Link headers of the response are preferred over links in the body.
+// The body is only parsed when the headers advertise no hubs.
+func DiscoverTopic(client *http.Client, topic *url.URL) (*Discovery, error) {
+	return DiscoverTopicContext(context.Background(), client, topic)
+}
+
+// Like DiscoverTopic but the request for the topic is cancelled when ctx is
+// done.
+func DiscoverTopicContext(ctx context.Context, client *http.Client, topic *url.URL) (*Discovery, error) {
+	req, err := http.NewRequest(http.MethodGet, topic.String(), nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	req = req.WithContext(ctx)
 
-	rawContentType := resp.Header.Get("Content-Type")
-	contentType, _, err := mime.ParseMediaType(rawContentType)
+	// get the topic, following any redirects
+	resp, err := client.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer resp.Body.Close()
 
-	suffix := strings.Split(contentType, "/")[1]
-	if suffix == "xml" {
-		// try parsing as an atom feed
+	base := resp.Request.URL
+	discovery := &Discovery{}
 
-		feed := struct {
-			Link []atomLink `xml:"http://www.w3.org/2005/Atom link"`
-		}{}
+	// try to get links from headers
+	for _, l := range parseLinkHeader(resp.Header["Link"]) {
+		if l.hasRel("hub") {
+			hub, err := l.resolve(base)
+			if err != nil {
+				return nil, err
+			}
+
+			discovery.addHub(hub)
+		}
+
+		if discovery.Self == nil && l.hasRel("self") {
+			discovery.Self, err = l.resolve(base)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(discovery.Hubs) == 0 {
+		// fall back to the body
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
 
-		xml.Unmarshal(body, &feed)
+		rawContentType := resp.Header.Get("Content-Type")
+		contentType, _, err := mime.ParseMediaType(rawContentType)
+		if err != nil {
+			return nil, err
+		}
+
+		var links []bodyLink
+		switch {
+		case isHTML(contentType):
+			links = htmlLinks(body)
+
+		case isXML(contentType):
+			links = xmlLinks(body)
+
+		default:
+			return nil, &ResponseError{
+				Response: resp,
+				Message:  "Unknown response type",
+			}
+		}
 
-		// find link rel="hub"
-		for i := 0; i < len(feed.Link); i++ {
-			link := feed.Link[i]
-			if link.Rel == "hub" {
-				s.Hub, err = url.Parse(link.Href)
+		// find links with rel="hub" and rel="self"
+		for _, link := range links {
+			if link.hasRel("hub") {
+				hub, err := base.Parse(link.Href)
 				if err != nil {
-					return err
+					return nil, err
 				}
 
-				break
+				discovery.addHub(hub)
 			}
-		}
 
-	} else {
-		return &ResponseError{
-			Response: resp,
-			Message:  "Unknown response type",
+			if discovery.Self == nil && link.hasRel("self") {
+				discovery.Self, err = base.Parse(link.Href)
+				if err != nil {
+					return nil, err
+				}
+			}
 		}
 	}
 
+	// the url we ended up at after redirects is canonical unless the topic
+	// says otherwise
+	if discovery.Self == nil {
+		discovery.Self = base
+	}
+
+	return discovery, nil
+}
+
+// Discovers the hubs of s.Topic using s.Client. The first advertised hub is
+// stored in s.Hub and every advertised hub is stored in s.Hubs.
+//
+// If the topic redirects or advertises a canonical url with rel="self", s.Topic
+// is replaced with the canonical url and the url it replaces is kept in
+// s.OriginalTopic.
+func (s *Sub) Discover() error {
+	return s.DiscoverContext(context.Background())
+}
+
+// Like Discover but the request for the topic is cancelled when ctx is done.
+func (s *Sub) DiscoverContext(ctx context.Context) error {
+	discovery, err := DiscoverTopicContext(ctx, s.Client, s.Topic)
+	if err != nil {
+		return err
+	}
+
+	if len(discovery.Hubs) > 0 {
+		s.stateLock.Lock()
+		s.Hub = discovery.Hubs[0]
+		s.Hubs = discovery.Hubs
+		s.stateLock.Unlock()
+	}
+
+	s.setCanonicalTopic(discovery.Self)
+
 	return nil
 }
 
+// Replaces the topic with the canonical topic, remembering the topic the user
+// configured.
+func (s *Sub) setCanonicalTopic(canonical *url.URL) {
+	if canonical.String() == s.Topic.String() {
+		return
+	}
+
+	if s.OriginalTopic == nil {
+		s.OriginalTopic = s.Topic
+	}
+
+	s.Topic = canonical
+}
+
+// Returns true if topic is the topic of this subscription, either as
+// configured or as advertised by the topic itself.
+func (s *Sub) isTopic(topic string) bool {
+	if s.Topic != nil && topic == s.Topic.String() {
+		return true
+	}
+
+	return s.OriginalTopic != nil && topic == s.OriginalTopic.String()
+}
+
+// A link found in the body of a topic.
+type bodyLink struct {
+	Rel  string
+	Href string
+}
+
+// Returns true if rel is one of the space separated relation types of the
+// link.
+func (l *bodyLink) hasRel(rel string) bool {
+	for _, r := range strings.Fields(l.Rel) {
+		if strings.EqualFold(r, rel) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns true for media types which should be parsed as HTML.
+func isHTML(contentType string) bool {
+	return contentType == "text/html" || contentType == "application/xhtml+xml"
+}
+
+// Returns true for xml media types, including those with a +xml suffix like
+// application/atom+xml and application/rss+xml.
+func isXML(contentType string) bool {
+	parts := strings.SplitN(contentType, "/", 2)
+	if len(parts) != 2 {
+		return false
+	}
+
+	subtype := parts[1]
+	return subtype == "xml" || strings.HasSuffix(subtype, "+xml")
+}
+
 type atomLink struct {
 	XMLName xml.Name `xml:"http://www.w3.org/2005/Atom link"`
 	Rel     string   `xml:"rel,attr"`
 	Href    string   `xml:"href,attr"`
 }
+
+// Finds the atom links of an Atom feed or an RSS 2.0 channel.
+func xmlLinks(body []byte) []bodyLink {
+	feed := struct {
+		// links of an atom feed
+		Link []atomLink `xml:"http://www.w3.org/2005/Atom link"`
+
+		// links embedded in a rss channel
+		Channel struct {
+			Link []atomLink `xml:"http://www.w3.org/2005/Atom link"`
+		} `xml:"channel"`
+	}{}
+
+	xml.Unmarshal(body, &feed)
+
+	links := []bodyLink{}
+	for _, link := range append(feed.Link, feed.Channel.Link...) {
+		links = append(links, bodyLink{Rel: link.Rel, Href: link.Href})
+	}
+
+	return links
+}
+
+// Finds the <link> elements in the <head> of a HTML document. The document is
+// parsed leniently, stopping at the body or the first error.
+func htmlLinks(body []byte) []bodyLink {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+
+	links := []bodyLink{}
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return links
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch strings.ToLower(start.Name.Local) {
+		case "body":
+			return links
+
+		case "link":
+			link := bodyLink{}
+			for _, attr := range start.Attr {
+				switch strings.ToLower(attr.Name.Local) {
+				case "rel":
+					link.Rel = attr.Value
+				case "href":
+					link.Href = attr.Value
+				}
+			}
+
+			links = append(links, link)
+		}
+	}
+}
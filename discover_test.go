@@ -4,6 +4,7 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 
 	"testing"
 )
@@ -44,32 +45,260 @@ func TestDiscoverFromAtom(t *testing.T) {
 	}
 
 	topicUrl := subscription.Topic.String()
-	if topicUrl != ts.URL {
+	if topicUrl != "https://www.youtube.com/xml/feeds/videos.xml" {
 		t.Error("Got incorrect topic url: " + topicUrl)
 	}
+
+	originalUrl := subscription.OriginalTopic.String()
+	if originalUrl != ts.URL {
+		t.Error("Got incorrect original topic url: " + originalUrl)
+	}
 }
 
-// func TestDiscoverFromHeaders(t *testing.T) {
-// 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-// 		w.Header().Add("Link", "<http://example.com/hub>; rel=hub")
-// 		w.Header().Add("Link", "<http://example.com/feed>; rel=self")
-// 	})
-//
-// 	ts := httptest.NewServer(handler)
-// 	defer ts.Close()
-//
-// 	subscription, err := Discover(ts.URL)
-// 	if err != nil {
-// 		t.Error(err)
-// 	}
-//
-// 	hubUrl := subscription.Hub.String()
-// 	if hubUrl != "http://example.com/hub" {
-// 		t.Error("Got incorrect hub url: " + hubUrl)
-// 	}
-//
-// 	topicUrl := subscription.Topic.String()
-// 	if topicUrl != "http://example.com/feed" {
-// 		t.Error("Got incorrect topic url: " + topicUrl)
-// 	}
-// }
+func TestDiscoverFromHeaders(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Link", "<http://example.com/hub>; rel=hub")
+		w.Header().Add("Link", "<http://example.com/feed>; rel=self")
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, "{}")
+	})
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	subscription := New()
+	subscription.Topic = MustParseUrl(ts.URL)
+
+	err := subscription.Discover()
+	if err != nil {
+		t.Error(err)
+	}
+
+	hubUrl := subscription.Hub.String()
+	if hubUrl != "http://example.com/hub" {
+		t.Error("Got incorrect hub url: " + hubUrl)
+	}
+
+	topicUrl := subscription.Topic.String()
+	if topicUrl != "http://example.com/feed" {
+		t.Error("Got incorrect topic url: " + topicUrl)
+	}
+}
+
+func TestDiscoverPrefersHeaders(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", `</hub>; rel="hub"`)
+		w.Header().Set("Content-Type", "text/xml")
+		io.WriteString(w, Atom)
+	})
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	subscription := New()
+	subscription.Topic = MustParseUrl(ts.URL)
+
+	err := subscription.Discover()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hubUrl := subscription.Hub.String()
+	if hubUrl != ts.URL+"/hub" {
+		t.Error("Got incorrect hub url: " + hubUrl)
+	}
+}
+
+func TestDiscoverFollowsRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/old", http.RedirectHandler("/feed", http.StatusMovedPermanently))
+	mux.HandleFunc("/feed", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "<http://example.com/hub>; rel=hub")
+	})
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	subscription := New()
+	subscription.Topic = MustParseUrl(ts.URL + "/old")
+
+	err := subscription.Discover()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	topicUrl := subscription.Topic.String()
+	if topicUrl != ts.URL+"/feed" {
+		t.Error("Got incorrect topic url: " + topicUrl)
+	}
+
+	if !subscription.isTopic(ts.URL+"/old") || !subscription.isTopic(ts.URL+"/feed") {
+		t.Error("Both topics should be accepted")
+	}
+}
+
+func TestDiscoverFromBody(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		hub         string
+		topic       string
+	}{
+		{
+			name:        "atom",
+			contentType: "application/atom+xml; charset=utf-8",
+			body:        Atom,
+			hub:         "http://pubsubhubbub.appspot.com",
+			topic:       "https://www.youtube.com/xml/feeds/videos.xml",
+		},
+		{
+			name:        "rss",
+			contentType: "application/rss+xml",
+			body: `<?xml version="1.0"?>
+<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom">
+  <channel>
+    <title>Blog</title>
+    <atom:link rel="hub" href="https://hub.example.com/" />
+    <atom:link rel="self" type="application/rss+xml" href="https://example.com/rss" />
+    <item><title>Post</title></item>
+  </channel>
+</rss>`,
+			hub:   "https://hub.example.com/",
+			topic: "https://example.com/rss",
+		},
+		{
+			name:        "rss as text/xml",
+			contentType: "text/xml",
+			body: `<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom">
+  <channel><atom:link rel="hub" href="https://hub.example.com/" /></channel>
+</rss>`,
+			hub: "https://hub.example.com/",
+		},
+		{
+			name:        "html",
+			contentType: "text/html; charset=utf-8",
+			body: `<!DOCTYPE html>
+<html>
+  <head>
+    <meta charset="utf-8">
+    <title>Blog &amp; Stuff</title>
+    <link rel="stylesheet" href="/style.css">
+    <link rel="hub" href="https://hub.example.com/">
+    <LINK REL="self" HREF="/feed">
+  </head>
+  <body><link rel="hub" href="https://ignored.example.com/"></body>
+</html>`,
+			hub:   "https://hub.example.com/",
+			topic: "/feed",
+		},
+		{
+			name:        "html in body is ignored",
+			contentType: "text/html",
+			body:        `<html><head></head><body><link rel="hub" href="https://hub/"></body></html>`,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", test.contentType)
+			io.WriteString(w, test.body)
+		})
+
+		ts := httptest.NewServer(handler)
+
+		subscription := New()
+		subscription.Topic = MustParseUrl(ts.URL)
+
+		err := subscription.Discover()
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		}
+
+		hubUrl := ""
+		if subscription.Hub != nil {
+			hubUrl = subscription.Hub.String()
+		}
+
+		if hubUrl != test.hub {
+			t.Errorf("%s: got incorrect hub url: %s", test.name, hubUrl)
+		}
+
+		expectedTopic := ts.URL
+		if strings.HasPrefix(test.topic, "/") {
+			expectedTopic = ts.URL + test.topic
+		} else if test.topic != "" {
+			expectedTopic = test.topic
+		}
+
+		topicUrl := subscription.Topic.String()
+		if topicUrl != expectedTopic {
+			t.Errorf("%s: got incorrect topic url: %s", test.name, topicUrl)
+		}
+
+		ts.Close()
+	}
+}
+
+func TestDiscoverUnknownType(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+	})
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	subscription := New()
+	subscription.Topic = MustParseUrl(ts.URL)
+
+	err := subscription.Discover()
+	if _, ok := err.(*ResponseError); !ok {
+		t.Error("Expected a ResponseError, got", err)
+	}
+}
+
+func TestIsXML(t *testing.T) {
+	tests := map[string]bool{
+		"text/xml":             true,
+		"application/xml":      true,
+		"application/atom+xml": true,
+		"application/rss+xml":  true,
+		"text/html":            false,
+		"application/json":     false,
+		"application/xmlfoo":   false,
+		"xml":                  false,
+	}
+
+	for contentType, expected := range tests {
+		if isXML(contentType) != expected {
+			t.Error("isXML", contentType, "expected", expected)
+		}
+	}
+}
+
+func TestDiscoverAllHubs(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Link", `<https://a.example.com/>; rel="hub", <https://b.example.com/>; rel="hub"`)
+		w.Header().Add("Link", `<https://a.example.com/>; rel="hub"`)
+		w.Header().Add("Link", `<https://example.com/feed>; rel="self"`)
+	})
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	discovery, err := DiscoverTopic(http.DefaultClient, MustParseUrl(ts.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(discovery.Hubs) != 2 ||
+		discovery.Hubs[0].String() != "https://a.example.com/" ||
+		discovery.Hubs[1].String() != "https://b.example.com/" {
+		t.Error("Got incorrect hubs", discovery.Hubs)
+	}
+
+	if discovery.Self.String() != "https://example.com/feed" {
+		t.Error("Got incorrect self url", discovery.Self)
+	}
+}
@@ -1,18 +1,108 @@
 package sub
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"time"
 )
 
-// This error is sent to Sub.OnError when unexpected requests are sent.
+var (
+	// Returned when a request needs a hub but none is configured or
+	// discovered.
+	ErrNoHub = errors.New("no hub")
+
+	// A verification request was for a topic other than the subscription's
+	// topic.
+	ErrTopicMismatch = errors.New("topic mismatch")
+
+	// A message arrived without a X-Hub-Signature header.
+	ErrMissingSignature = errors.New("missing signature")
+
+	// A message arrived with a X-Hub-Signature header which isn't of the form
+	// algorithm=hexdigest.
+	ErrMalformedSignature = errors.New("malformed signature")
+
+	// A message arrived with a signature which doesn't match the body.
+	ErrBadSignature = errors.New("bad signature")
+
+	// A message arrived for a subscription without a secret, so it can't be
+	// authenticated. Set Sub.AllowUnsigned to accept such messages.
+	ErrNoSecret = errors.New("no secret")
+
+	// A verification request had a mode which wasn't expected in the current
+	// state of the subscription.
+	ErrUnexpectedMode = errors.New("unexpected mode")
+
+	// A verification request had a missing or malformed hub.lease_seconds.
+	ErrBadLease = errors.New("bad lease")
+
+	// A verification request arrived after the request it confirms timed out.
+	// See Sub.PendingTimeout.
+	ErrIntentExpired = errors.New("pending request expired")
+
+	// A request which is neither a verification nor a message.
+	ErrUnknownRequest = errors.New("unknown request")
+)
+
+// This error is sent to Sub.OnError when unexpected requests are sent. Err
+// describes what was wrong with the request and can be inspected with
+// errors.Is and errors.As.
 type RequestError struct {
 	Request *http.Request
-	Message string
+	Err     error
 }
 
 func (e *RequestError) Error() string {
-	return fmt.Sprintf("%s %#v", e.Message, e.Request)
+	return e.Request.Method + " request: " + e.Err.Error()
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// This error describes a verification request with a mode which isn't valid in
+// the current state. It matches ErrUnexpectedMode.
+type ModeError struct {
+	Mode  string
+	State State
+}
+
+func (e *ModeError) Error() string {
+	return fmt.Sprintf("unexpected mode %q in state %s", e.Mode, e.State)
+}
+
+func (e *ModeError) Is(target error) bool {
+	return target == ErrUnexpectedMode
+}
+
+// This error describes a verification request which doesn't match the request
+// pending with the hub, like a subscribe verification after requesting to
+// unsubscribe or a denial while nothing was requested. Err is
+// ErrUnexpectedMode or ErrIntentExpired.
+type IntentError struct {
+	// The mode of the verification request.
+	Mode string
+
+	// The mode of the pending request and the time it was sent at. Pending is
+	// empty when nothing was requested.
+	Pending string
+	Sent    time.Time
+
+	Err error
+}
+
+func (e *IntentError) Error() string {
+	if e.Pending == "" {
+		return fmt.Sprintf("%s verification without a pending request: %s", e.Mode, e.Err)
+	}
+
+	return fmt.Sprintf("%s verification for %s request sent at %s: %s",
+		e.Mode, e.Pending, e.Sent.Format(time.RFC3339), e.Err)
+}
+
+func (e *IntentError) Unwrap() error {
+	return e.Err
 }
 
 // This error is sent to Sub.OnError when a subscription is denied.
@@ -25,11 +115,12 @@ func (e *DeniedError) Error() string {
 	return e.Topic + ": " + e.Reason
 }
 
+// This error is returned when a hub or topic responds unexpectedly.
 type ResponseError struct {
 	Response *http.Response
 	Message  string
 }
 
 func (e *ResponseError) Error() string {
-	return fmt.Sprintf("%s %#v", e.Message, e.Response)
+	return fmt.Sprintf("%s: %s", e.Message, e.Response.Status)
 }
@@ -0,0 +1,92 @@
+package sub
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRequestErrorKinds(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  url.Values
+		target error
+	}{
+		{
+			name: "topic mismatch",
+			query: url.Values{
+				"hub.topic": {"https://example.com/other"},
+				"hub.mode":  {subscribeMode},
+			},
+			target: ErrTopicMismatch,
+		},
+		{
+			name: "unexpected mode",
+			query: url.Values{
+				"hub.topic": {"https://example.com/feed"},
+				"hub.mode":  {"publish"},
+			},
+			target: ErrUnexpectedMode,
+		},
+		{
+			name: "mismatched intent",
+			query: url.Values{
+				"hub.topic": {"https://example.com/feed"},
+				"hub.mode":  {unsubscribeMode},
+			},
+			target: ErrUnexpectedMode,
+		},
+		{
+			name: "bad lease",
+			query: url.Values{
+				"hub.topic":         {"https://example.com/feed"},
+				"hub.mode":          {subscribeMode},
+				"hub.lease_seconds": {"forever"},
+			},
+			target: ErrBadLease,
+		},
+	}
+
+	for _, test := range tests {
+		var gotErr error
+		s := &Sub{
+			Topic:   MustParseUrl("https://example.com/feed"),
+			OnError: func(err error) { gotErr = err },
+		}
+		s.request(subscribeMode, 0)
+
+		secretPath := "/callback/" + string(RandAlphanumBytes(20))
+		req := httptest.NewRequest(http.MethodGet,
+			secretPath+"?"+test.query.Encode(), nil)
+		s.ServeHTTP(httptest.NewRecorder(), req)
+
+		if !errors.Is(gotErr, test.target) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.target, gotErr)
+		}
+
+		var requestErr *RequestError
+		if !errors.As(gotErr, &requestErr) || requestErr.Request != req {
+			t.Errorf("%s: expected a RequestError, got %#v", test.name, gotErr)
+		}
+
+		if gotErr != nil && strings.Contains(gotErr.Error(), secretPath) {
+			t.Errorf("%s: error leaks the request: %s", test.name, gotErr)
+		}
+	}
+}
+
+func TestModeError(t *testing.T) {
+	var err error = &ModeError{Mode: subscribeMode, State: Subscribed}
+
+	if !errors.Is(err, ErrUnexpectedMode) {
+		t.Error("ModeError should match ErrUnexpectedMode")
+	}
+
+	expected := `unexpected mode "subscribe" in state Subscribed`
+	if err.Error() != expected {
+		t.Error("Got", err.Error(), "expected", expected)
+	}
+}
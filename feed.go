@@ -0,0 +1,447 @@
+package sub
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Returned by ParseFeed for content which isn't an Atom, RSS 2.0 or JSON Feed
+// document.
+var ErrUnknownFeedType = errors.New("unknown feed type")
+
+// An entry of a feed. The fields are filled from the equivalent elements of
+// Atom, RSS 2.0 and JSON Feed documents and are empty when missing.
+type Entry struct {
+	// The unique id of the entry. For RSS items without a guid, this is the
+	// link.
+	ID string
+
+	Title string
+
+	// The url of the page the entry is about.
+	Link string
+
+	// The name of the first author.
+	Author string
+
+	// Text or HTML. Atom XHTML is the markup inside its wrapping div.
+	Summary string
+	Content string
+
+	Published time.Time
+	Updated   time.Time
+
+	// The entry as it appeared in the document: the <entry> or <item>
+	// element or the JSON Feed item object. The XML namespaces declared
+	// around the element are declared on it too, so it can be parsed on its
+	// own.
+	Raw []byte
+}
+
+// Parses the entries of an Atom, RSS 2.0 or JSON Feed document. The format is
+// chosen by contentType and sniffed from body when contentType is empty.
+// ErrUnknownFeedType is returned for other documents.
+func ParseFeed(contentType string, body []byte) ([]Entry, error) {
+	if contentType == "" {
+		return sniffFeed(body)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case isXML(mediaType):
+		return parseXMLFeed(body)
+
+	case isJSONFeed(mediaType):
+		return parseJSONFeed(body)
+
+	default:
+		return nil, ErrUnknownFeedType
+	}
+}
+
+// Parses body as the format it looks like.
+func sniffFeed(body []byte) ([]Entry, error) {
+	trimmed := bytes.TrimSpace(body)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("<")):
+		return parseXMLFeed(body)
+
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		return parseJSONFeed(body)
+
+	default:
+		return nil, ErrUnknownFeedType
+	}
+}
+
+// Returns true for media types which may hold a JSON Feed.
+func isJSONFeed(contentType string) bool {
+	return contentType == "application/feed+json" ||
+		contentType == "application/json"
+}
+
+const atomNamespace = "http://www.w3.org/2005/Atom"
+
+type atomEntry struct {
+	ID        string     `xml:"http://www.w3.org/2005/Atom id"`
+	Title     string     `xml:"http://www.w3.org/2005/Atom title"`
+	Link      []atomLink `xml:"http://www.w3.org/2005/Atom link"`
+	Author    []string   `xml:"http://www.w3.org/2005/Atom author>name"`
+	Summary   atomText   `xml:"http://www.w3.org/2005/Atom summary"`
+	Content   atomText   `xml:"http://www.w3.org/2005/Atom content"`
+	Published string     `xml:"http://www.w3.org/2005/Atom published"`
+	Updated   string     `xml:"http://www.w3.org/2005/Atom updated"`
+}
+
+// The summary or content of an Atom entry. Text and HTML are escaped text,
+// XHTML and other XML media types are markup.
+type atomText struct {
+	Type  string `xml:"type,attr"`
+	Text  string `xml:",chardata"`
+	Inner string `xml:",innerxml"`
+}
+
+// Returns the text, HTML or markup.
+func (t *atomText) value() string {
+	switch {
+	case t.Type == "xhtml":
+		// the markup is wrapped in a xhtml div which isn't part of it
+		div := struct {
+			Inner string `xml:",innerxml"`
+		}{}
+
+		if xml.Unmarshal([]byte(t.Inner), &div) != nil {
+			return t.Inner
+		}
+
+		return div.Inner
+
+	case strings.HasSuffix(t.Type, "xml"):
+		return t.Inner
+
+	default:
+		return t.Text
+	}
+}
+
+func (e *atomEntry) entry() Entry {
+	entry := Entry{
+		ID:        strings.TrimSpace(e.ID),
+		Title:     strings.TrimSpace(e.Title),
+		Summary:   e.Summary.value(),
+		Content:   e.Content.value(),
+		Published: parseFeedTime(e.Published),
+		Updated:   parseFeedTime(e.Updated),
+	}
+
+	for _, link := range e.Link {
+		if link.Rel == "" || link.Rel == "alternate" {
+			entry.Link = link.Href
+			break
+		}
+	}
+
+	if len(e.Author) > 0 {
+		entry.Author = strings.TrimSpace(e.Author[0])
+	}
+
+	return entry
+}
+
+type rssItem struct {
+	GUID        string `xml:"guid"`
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	Author      string `xml:"author"`
+	Creator     string `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Description string `xml:"description"`
+	Content     string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	PubDate     string `xml:"pubDate"`
+}
+
+func (i *rssItem) entry() Entry {
+	entry := Entry{
+		ID:        strings.TrimSpace(i.GUID),
+		Title:     strings.TrimSpace(i.Title),
+		Link:      strings.TrimSpace(i.Link),
+		Author:    strings.TrimSpace(i.Author),
+		Summary:   i.Description,
+		Content:   i.Content,
+		Published: parseFeedTime(i.PubDate),
+	}
+
+	if entry.ID == "" {
+		entry.ID = entry.Link
+	}
+
+	if entry.Author == "" {
+		entry.Author = strings.TrimSpace(i.Creator)
+	}
+
+	return entry
+}
+
+// Parses the <entry> elements of an Atom feed or the <item> elements of a
+// RSS 2.0 channel.
+func parseXMLFeed(body []byte) ([]Entry, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+
+	entries := []Entry{}
+	root := true
+
+	// the namespaces declared around the entries by prefix
+	namespaces := map[string]string{}
+	for {
+		// the offset of the next token, used to slice the raw entries
+		start := decoder.InputOffset()
+
+		token, err := decoder.Token()
+		if err == io.EOF {
+			if root {
+				return nil, ErrUnknownFeedType
+			}
+
+			return entries, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		element, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		if root {
+			// only parse documents which are feeds
+			isAtom := element.Name.Space == atomNamespace && element.Name.Local == "feed"
+			if !isAtom && element.Name.Local != "rss" {
+				return nil, ErrUnknownFeedType
+			}
+
+			root = false
+			addNamespaces(namespaces, element)
+			continue
+		}
+
+		var entry Entry
+		switch {
+		case element.Name.Space == atomNamespace && element.Name.Local == "entry":
+			atom := atomEntry{}
+			err = decoder.DecodeElement(&atom, &element)
+			entry = atom.entry()
+
+		case element.Name.Space == "" && element.Name.Local == "item":
+			item := rssItem{}
+			err = decoder.DecodeElement(&item, &element)
+			entry = item.entry()
+
+		default:
+			// descend into the channel and other containers
+			addNamespaces(namespaces, element)
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		entry.Raw = declareNamespaces(body[start:decoder.InputOffset()], element, namespaces)
+		entries = append(entries, entry)
+	}
+}
+
+// Returns the prefix declared by a xmlns attribute. The default namespace has
+// the empty prefix.
+func namespacePrefix(name xml.Name) (string, bool) {
+	switch {
+	case name.Space == "xmlns":
+		return name.Local, true
+
+	case name.Space == "" && name.Local == "xmlns":
+		return "", true
+
+	default:
+		return "", false
+	}
+}
+
+// Remembers the namespaces declared by element.
+func addNamespaces(namespaces map[string]string, element xml.StartElement) {
+	for _, attr := range element.Attr {
+		if prefix, ok := namespacePrefix(attr.Name); ok {
+			namespaces[prefix] = attr.Value
+		}
+	}
+}
+
+// Returns raw, the source of element, with the namespaces which element
+// doesn't declare itself declared on it.
+func declareNamespaces(raw []byte, element xml.StartElement, namespaces map[string]string) []byte {
+	declared := map[string]bool{}
+	for _, attr := range element.Attr {
+		if prefix, ok := namespacePrefix(attr.Name); ok {
+			declared[prefix] = true
+		}
+	}
+
+	prefixes := []string{}
+	for prefix := range namespaces {
+		if !declared[prefix] {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+
+	if len(prefixes) == 0 {
+		return raw
+	}
+	sort.Strings(prefixes)
+
+	declarations := &bytes.Buffer{}
+	for _, prefix := range prefixes {
+		name := "xmlns"
+		if prefix != "" {
+			name += ":" + prefix
+		}
+
+		declarations.WriteString(" " + name + `="`)
+		xml.EscapeText(declarations, []byte(namespaces[prefix]))
+		declarations.WriteString(`"`)
+	}
+
+	// the declarations go right after the name of the element
+	nameEnd := bytes.IndexAny(raw, " \t\r\n/>")
+	if nameEnd < 0 {
+		return raw
+	}
+
+	result := make([]byte, 0, len(raw)+declarations.Len())
+	result = append(result, raw[:nameEnd]...)
+	result = append(result, declarations.Bytes()...)
+	return append(result, raw[nameEnd:]...)
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+type jsonFeedItem struct {
+	ID            json.RawMessage  `json:"id"`
+	URL           string           `json:"url"`
+	Title         string           `json:"title"`
+	Summary       string           `json:"summary"`
+	ContentHTML   string           `json:"content_html"`
+	ContentText   string           `json:"content_text"`
+	DatePublished string           `json:"date_published"`
+	DateModified  string           `json:"date_modified"`
+	Author        *jsonFeedAuthor  `json:"author"`
+	Authors       []jsonFeedAuthor `json:"authors"`
+}
+
+func (i *jsonFeedItem) entry() Entry {
+	entry := Entry{
+		ID:        jsonFeedID(i.ID),
+		Title:     i.Title,
+		Link:      i.URL,
+		Summary:   i.Summary,
+		Content:   i.ContentHTML,
+		Published: parseFeedTime(i.DatePublished),
+		Updated:   parseFeedTime(i.DateModified),
+	}
+
+	if entry.Content == "" {
+		entry.Content = i.ContentText
+	}
+
+	if len(i.Authors) > 0 {
+		entry.Author = i.Authors[0].Name
+	} else if i.Author != nil {
+		entry.Author = i.Author.Name
+	}
+
+	return entry
+}
+
+// Returns the id of a JSON Feed item. Ids should be strings, but numbers are
+// common too.
+func jsonFeedID(raw json.RawMessage) string {
+	var id string
+	if json.Unmarshal(raw, &id) == nil {
+		return id
+	}
+
+	return string(raw)
+}
+
+// Parses the items of a JSON Feed. Other JSON documents are of an unknown feed
+// type.
+func parseJSONFeed(body []byte) ([]Entry, error) {
+	header := struct {
+		Version string `json:"version"`
+	}{}
+
+	err := json.Unmarshal(body, &header)
+	if err != nil || !strings.HasPrefix(header.Version, "https://jsonfeed.org/version/") {
+		return nil, ErrUnknownFeedType
+	}
+
+	feed := struct {
+		Items []json.RawMessage `json:"items"`
+	}{}
+
+	err = json.Unmarshal(body, &feed)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []Entry{}
+	for _, raw := range feed.Items {
+		item := jsonFeedItem{}
+		err := json.Unmarshal(raw, &item)
+		if err != nil {
+			return nil, fmt.Errorf("parsing item: %w", err)
+		}
+
+		entry := item.entry()
+		entry.Raw = raw
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// The layouts of dates in feeds. Atom and JSON Feed use RFC 3339 and RSS uses
+// RFC 822 with a few common deviations.
+var feedTimeLayouts = []string{
+	time.RFC3339,
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	time.RFC822Z,
+	time.RFC822,
+}
+
+// Parses a date of a feed. The zero time is returned when it can't be parsed.
+func parseFeedTime(raw string) time.Time {
+	raw = strings.TrimSpace(raw)
+	for _, layout := range feedTimeLayouts {
+		t, err := time.Parse(layout, raw)
+		if err == nil {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
@@ -0,0 +1,306 @@
+package sub
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const atomFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <link rel="hub" href="https://hub.example.com/"/>
+  <title>Example</title>
+  <entry>
+    <id>urn:entry:1</id>
+    <title>First</title>
+    <link rel="alternate" href="https://example.com/1"/>
+    <author><name>Ada</name></author>
+    <published>2017-06-01T10:00:00Z</published>
+    <updated>2017-06-02T10:00:00Z</updated>
+    <summary>Summary</summary>
+  </entry>
+  <entry>
+    <id>urn:entry:2</id>
+    <title>Second</title>
+  </entry>
+</feed>`
+
+const rssFeed = `<?xml version="1.0"?>
+<rss version="2.0" xmlns:dc="http://purl.org/dc/elements/1.1/">
+  <channel>
+    <title>Example</title>
+    <link>https://example.com/</link>
+    <item>
+      <title>First</title>
+      <link>https://example.com/1</link>
+      <guid>urn:item:1</guid>
+      <dc:creator>Ada</dc:creator>
+      <description>Summary</description>
+      <pubDate>Thu, 01 Jun 2017 10:00:00 +0000</pubDate>
+    </item>
+    <item>
+      <title>Second</title>
+      <link>https://example.com/2</link>
+    </item>
+  </channel>
+</rss>`
+
+const jsonFeed = `{
+  "version": "https://jsonfeed.org/version/1.1",
+  "title": "Example",
+  "items": [
+    {
+      "id": "urn:item:1",
+      "url": "https://example.com/1",
+      "title": "First",
+      "content_text": "Content",
+      "date_published": "2017-06-01T10:00:00Z",
+      "authors": [{"name": "Ada"}]
+    },
+    {"id": 2, "title": "Second"}
+  ]
+}`
+
+func TestParseFeed(t *testing.T) {
+	published := time.Date(2017, 6, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		first       Entry
+		secondID    string
+		rawPrefix   string
+	}{
+		{
+			name:        "atom",
+			contentType: "application/atom+xml; charset=utf-8",
+			body:        atomFeed,
+			first: Entry{
+				ID:        "urn:entry:1",
+				Title:     "First",
+				Link:      "https://example.com/1",
+				Author:    "Ada",
+				Summary:   "Summary",
+				Published: published,
+				Updated:   published.Add(24 * time.Hour),
+			},
+			secondID:  "urn:entry:2",
+			rawPrefix: `<entry xmlns="http://www.w3.org/2005/Atom">`,
+		},
+		{
+			name:        "rss",
+			contentType: "application/rss+xml",
+			body:        rssFeed,
+			first: Entry{
+				ID:        "urn:item:1",
+				Title:     "First",
+				Link:      "https://example.com/1",
+				Author:    "Ada",
+				Summary:   "Summary",
+				Published: published,
+			},
+			secondID:  "https://example.com/2",
+			rawPrefix: `<item xmlns:dc="http://purl.org/dc/elements/1.1/">`,
+		},
+		{
+			name:        "json feed",
+			contentType: "application/feed+json",
+			body:        jsonFeed,
+			first: Entry{
+				ID:        "urn:item:1",
+				Title:     "First",
+				Link:      "https://example.com/1",
+				Author:    "Ada",
+				Content:   "Content",
+				Published: published,
+			},
+			secondID:  "2",
+			rawPrefix: "{",
+		},
+		{
+			name:      "sniffed",
+			body:      rssFeed,
+			first:     Entry{ID: "urn:item:1"},
+			secondID:  "https://example.com/2",
+			rawPrefix: `<item xmlns:dc="http://purl.org/dc/elements/1.1/">`,
+		},
+	}
+
+	for _, test := range tests {
+		entries, err := ParseFeed(test.contentType, []byte(test.body))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if len(entries) != 2 {
+			t.Errorf("%s: expected 2 entries, got %d", test.name, len(entries))
+			continue
+		}
+
+		first := entries[0]
+		if !strings.HasPrefix(string(first.Raw), test.rawPrefix) ||
+			!strings.Contains(string(first.Raw), test.first.ID) {
+			t.Errorf("%s: unexpected raw entry %q", test.name, first.Raw)
+		}
+
+		if test.contentType != "" {
+			first.Raw = nil
+			if first.ID != test.first.ID || first.Title != test.first.Title ||
+				first.Link != test.first.Link || first.Author != test.first.Author ||
+				first.Summary != test.first.Summary || first.Content != test.first.Content ||
+				!first.Published.Equal(test.first.Published) ||
+				!first.Updated.Equal(test.first.Updated) {
+				t.Errorf("%s: expected %+v, got %+v", test.name, test.first, first)
+			}
+		}
+
+		if entries[1].ID != test.secondID {
+			t.Errorf("%s: expected id %q, got %q", test.name, test.secondID, entries[1].ID)
+		}
+	}
+}
+
+func TestParseFeedUnknown(t *testing.T) {
+	tests := []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain", "hello"},
+		{"application/xml", "<html></html>"},
+		{"application/json", `{"hello": "world"}`},
+		{"application/json", `[1, 2]`},
+		{"application/json", `"hello"`},
+		{"application/json", `{"version": 1}`},
+		{"", `[{"version": "https://jsonfeed.org/version/1.1"}]`},
+		{"", "hello"},
+	}
+
+	for _, test := range tests {
+		_, err := ParseFeed(test.contentType, []byte(test.body))
+		if err != ErrUnknownFeedType {
+			t.Error(test.contentType, "expected ErrUnknownFeedType, got", err)
+		}
+	}
+}
+
+func TestOnEntries(t *testing.T) {
+	var entries []Entry
+	var messages []string
+	s := &Sub{
+		AllowUnsigned: true,
+		OnEntries: func(r *http.Request, got []Entry) {
+			entries = append(entries, got...)
+		},
+		OnMessage: func(r *http.Request, body []byte) {
+			messages = append(messages, string(body))
+		},
+		OnError: func(err error) { t.Error(err) },
+	}
+
+	deliver := func(contentType, body string) {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		s.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	deliver("application/atom+xml", atomFeed)
+	deliver("text/plain", "not a feed")
+
+	if len(entries) != 2 || entries[0].ID != "urn:entry:1" {
+		t.Error("Unexpected entries", entries)
+	}
+
+	if len(messages) != 1 || messages[0] != "not a feed" {
+		t.Error("Expected unknown messages to be passed to OnMessage, got", messages)
+	}
+}
+
+func TestParseFeedRawNamespaces(t *testing.T) {
+	body := `<feed xmlns="http://www.w3.org/2005/Atom" xmlns:yt="http://www.youtube.com/xml/schemas/2015">
+  <entry>
+    <id>urn:entry:1</id>
+    <yt:videoId>abc</yt:videoId>
+  </entry>
+  <entry xmlns="http://www.w3.org/2005/Atom">
+    <id>urn:entry:2</id>
+  </entry>
+</feed>`
+
+	entries, err := ParseFeed("application/atom+xml", []byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the entries can be parsed without the feed
+	raw := struct {
+		ID      string `xml:"http://www.w3.org/2005/Atom id"`
+		VideoID string `xml:"http://www.youtube.com/xml/schemas/2015 videoId"`
+	}{}
+
+	err = xml.Unmarshal(entries[0].Raw, &raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if raw.ID != "urn:entry:1" || raw.VideoID != "abc" {
+		t.Errorf("Unexpected raw entry %q", entries[0].Raw)
+	}
+
+	// namespaces aren't declared twice
+	expected := `<entry xmlns:yt="http://www.youtube.com/xml/schemas/2015" ` +
+		`xmlns="http://www.w3.org/2005/Atom">`
+	if !strings.HasPrefix(string(entries[1].Raw), expected) {
+		t.Errorf("Unexpected raw entry %q", entries[1].Raw)
+	}
+}
+
+func TestParseAtomContent(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{name: "text", content: `<content>Hello</content>`, expected: "Hello"},
+		{
+			name:     "html",
+			content:  `<content type="html">&lt;p&gt;Hello&lt;/p&gt;</content>`,
+			expected: "<p>Hello</p>",
+		},
+		{
+			name:     "html in cdata",
+			content:  `<content type="html"><![CDATA[<p>Hello</p>]]></content>`,
+			expected: "<p>Hello</p>",
+		},
+		{
+			name: "xhtml",
+			content: `<content type="xhtml"><div xmlns="http://www.w3.org/1999/xhtml">` +
+				`<p>Hello <b>world</b></p></div></content>`,
+			expected: "<p>Hello <b>world</b></p>",
+		},
+		{
+			name:     "xml",
+			content:  `<content type="application/xml"><greeting>Hello</greeting></content>`,
+			expected: "<greeting>Hello</greeting>",
+		},
+	}
+
+	for _, test := range tests {
+		body := `<feed xmlns="http://www.w3.org/2005/Atom"><entry><id>urn:entry:1</id>` +
+			test.content + `</entry></feed>`
+
+		entries, err := ParseFeed("application/atom+xml", []byte(body))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if len(entries) != 1 || entries[0].Content != test.expected {
+			t.Errorf("%s: unexpected entries %+v", test.name, entries)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package sub
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// Returns true for messages without content, which tell the subscriber to
+// fetch the topic itself.
+func isThinPing(message []byte) bool {
+	return len(bytes.TrimSpace(message)) == 0
+}
+
+// Fetches the topic with a conditional request using the validators of the
+// last fetch. Returns false when the topic didn't change since then.
+func (s *Sub) fetchTopic(ctx context.Context) (string, []byte, bool, error) {
+	s.fetchLock.Lock()
+	defer s.fetchLock.Unlock()
+
+	req, err := http.NewRequest(http.MethodGet, s.Topic.String(), nil)
+	if err != nil {
+		return "", nil, false, err
+	}
+	req = req.WithContext(ctx)
+
+	if s.topicETag != "" {
+		req.Header.Set("If-None-Match", s.topicETag)
+	}
+
+	if s.topicLastModified != "" {
+		req.Header.Set("If-Modified-Since", s.topicLastModified)
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return "", nil, false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return "", nil, false, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, false, &ResponseError{
+			Response: resp,
+			Message:  "Fetching the topic failed",
+		}
+	}
+
+	var body io.Reader = resp.Body
+	maxSize := s.maxBodySize()
+	if maxSize >= 0 {
+		// read one byte past the limit to notice topics which are too large
+		body = io.LimitReader(body, maxSize+1)
+	}
+
+	content, err := ioutil.ReadAll(body)
+	if err != nil {
+		return "", nil, false, err
+	}
+
+	if maxSize >= 0 && int64(len(content)) > maxSize {
+		return "", nil, false, ErrBodyTooLarge
+	}
+
+	s.topicETag = resp.Header.Get("ETag")
+	s.topicLastModified = resp.Header.Get("Last-Modified")
+
+	return resp.Header.Get("Content-Type"), content, true, nil
+}
@@ -0,0 +1,79 @@
+package sub
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFetchThinPings(t *testing.T) {
+	fetches := 0
+	fail := false
+	topic := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Type", "application/atom+xml")
+		w.Write([]byte(atomFeed))
+	}))
+	defer topic.Close()
+
+	var entries []Entry
+	var messages []string
+	s := &Sub{
+		Topic:          MustParseUrl(topic.URL),
+		Client:         http.DefaultClient,
+		AllowUnsigned:  true,
+		FetchThinPings: true,
+		OnEntries: func(r *http.Request, got []Entry) {
+			entries = append(entries, got...)
+		},
+		OnMessage: func(r *http.Request, body []byte) {
+			messages = append(messages, string(body))
+		},
+	}
+
+	deliver := func(body string) int {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		if body != "" {
+			r.Header.Set("Content-Type", "text/plain")
+		}
+
+		rw := httptest.NewRecorder()
+		s.ServeHTTP(rw, r)
+		return rw.Code
+	}
+
+	deliver("")
+	if fetches != 1 || len(entries) != 2 {
+		t.Fatal("Expected the topic to be fetched and delivered", fetches, len(entries))
+	}
+
+	// not modified
+	deliver("\n")
+	if fetches != 2 || len(entries) != 2 {
+		t.Error("Expected an unmodified topic to be skipped", fetches, len(entries))
+	}
+
+	// fat pings are delivered as they are
+	deliver("content")
+	if fetches != 2 || len(messages) != 1 {
+		t.Error("Expected the content to be delivered", fetches, messages)
+	}
+
+	// the hub is asked to deliver again when fetching fails
+	fail = true
+	if code := deliver(""); code != http.StatusInternalServerError {
+		t.Error("Expected the delivery to fail, got", code)
+	}
+}
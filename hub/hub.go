@@ -0,0 +1,468 @@
+/*
+This package provides a hub for v0.4 of the PubSubHubbub protocol. It accepts
+subscription requests like the ones sent by the sub package, verifies the
+intent of subscribers, tracks their leases and distributes signed content to
+them. It runs fully in-process, so it can be embedded into a publisher or used
+as a local counterpart for tests.
+*/
+package hub
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/0xcaff/sub"
+)
+
+const (
+	subscribeMode   = "subscribe"
+	unsubscribeMode = "unsubscribe"
+	deniedMode      = "denied"
+	publishMode     = "publish"
+)
+
+// The longest secret subscribers may use.
+const maxSecretLen = 200 - 1
+
+var (
+	// Sent to OnError when a subscriber didn't echo the challenge while
+	// verifying intent.
+	ErrChallengeMismatch = errors.New("challenge mismatch")
+
+	// Returned by Publish when a subscriber rejected a delivery.
+	ErrDeliveryFailed = errors.New("delivery failed")
+)
+
+// An active subscription on the hub.
+type Subscription struct {
+	Topic    string
+	Callback string
+
+	// The secret used to sign content sent to the subscriber. Content is
+	// unsigned when empty.
+	Secret []byte
+
+	// The time at which the subscription lapses.
+	Expiry time.Time
+}
+
+// Represents a PubSubHubbub hub. It implements http.Handler to accept
+// subscription requests and publish notifications.
+type Hub struct {
+	// The public url of the hub. When set, it is advertised with rel="hub" in
+	// the Link header of deliveries.
+	URL *url.URL
+
+	// The client which is used to verify intent, fetch topics and deliver
+	// content.
+	Client *http.Client
+
+	// The lease used when a subscriber doesn't request one.
+	DefaultLease time.Duration
+
+	// The longest lease granted. Longer requests are shortened.
+	MaxLease time.Duration
+
+	// The algorithm used to sign content for subscribers with secrets.
+	SignatureAlgorithm sub.SignatureAlgorithm
+
+	// Called before verifying the intent of a subscriber. When a non-nil error
+	// is returned, the subscription is denied with the error as the reason.
+	Validate func(topic, callback string) error
+
+	// Called before fetching the topics of a publish request. When a non-nil
+	// error is returned, the request is rejected and nothing is fetched. Use
+	// it to only accept notifications from known publishers.
+	ValidatePublish func(r *http.Request, topics []string) error
+
+	// Called with errors which happen after a request was accepted, like
+	// failed verifications and deliveries.
+	OnError func(err error)
+
+	// Returns the current time for lease math. time.Now is used when nil.
+	Now func() time.Time
+
+	lock sync.Mutex
+
+	// subscriptions by topic then callback
+	subs map[string]map[string]*Subscription
+
+	// tracks verifications and publishes in progress
+	pending sync.WaitGroup
+}
+
+func New() *Hub {
+	return &Hub{
+		Client:             http.DefaultClient,
+		DefaultLease:       10 * 24 * time.Hour,
+		MaxLease:           30 * 24 * time.Hour,
+		SignatureAlgorithm: sub.SHA256,
+		subs:               map[string]map[string]*Subscription{},
+	}
+}
+
+// Implements http.Handler to handle form encoded subscription and publish
+// requests. Requests are answered right away and processed in the
+// background.
+func (h *Hub) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(rw, "Only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch mode := r.PostForm.Get("hub.mode"); mode {
+	case subscribeMode, unsubscribeMode:
+		h.handleSubscription(rw, r.PostForm)
+
+	case publishMode:
+		h.handlePublish(rw, r)
+
+	default:
+		http.Error(rw, "Unknown hub.mode: "+mode, http.StatusBadRequest)
+	}
+}
+
+// Validates a subscription request then verifies intent in the background.
+func (h *Hub) handleSubscription(rw http.ResponseWriter, values url.Values) {
+	mode := values.Get("hub.mode")
+	topic := values.Get("hub.topic")
+	if topic == "" {
+		http.Error(rw, "Missing hub.topic", http.StatusBadRequest)
+		return
+	}
+
+	callback, err := url.Parse(values.Get("hub.callback"))
+	if err != nil || !callback.IsAbs() ||
+		(callback.Scheme != "http" && callback.Scheme != "https") {
+		http.Error(rw, "Invalid hub.callback", http.StatusBadRequest)
+		return
+	}
+
+	secret := values.Get("hub.secret")
+	if len(secret) > maxSecretLen {
+		http.Error(rw, "hub.secret is too long", http.StatusBadRequest)
+		return
+	}
+
+	lease := h.DefaultLease
+	if rawLease := values.Get("hub.lease_seconds"); rawLease != "" {
+		seconds, err := strconv.ParseInt(rawLease, 10, 64)
+		if err != nil || seconds <= 0 {
+			http.Error(rw, "Invalid hub.lease_seconds", http.StatusBadRequest)
+			return
+		}
+
+		lease = time.Duration(seconds) * time.Second
+	}
+
+	if h.MaxLease > 0 && lease > h.MaxLease {
+		lease = h.MaxLease
+	}
+
+	rw.WriteHeader(http.StatusAccepted)
+
+	subscription := &Subscription{
+		Topic:    topic,
+		Callback: callback.String(),
+		Secret:   []byte(secret),
+	}
+
+	h.pending.Add(1)
+	go func() {
+		defer h.pending.Done()
+
+		err := h.verify(mode, subscription, lease)
+		if err != nil {
+			h.handleError(err)
+		}
+	}()
+}
+
+// Verifies the intent of a subscriber and applies the change when confirmed.
+// Subscriptions rejected by Validate are denied instead.
+func (h *Hub) verify(mode string, subscription *Subscription, lease time.Duration) error {
+	if mode == subscribeMode && h.Validate != nil {
+		reason := h.Validate(subscription.Topic, subscription.Callback)
+		if reason != nil {
+			return h.deny(subscription, reason.Error())
+		}
+	}
+
+	challenge := string(sub.RandAlphanumBytes(32))
+
+	query := url.Values{}
+	query.Set("hub.mode", mode)
+	query.Set("hub.topic", subscription.Topic)
+	query.Set("hub.challenge", challenge)
+	if mode == subscribeMode {
+		query.Set("hub.lease_seconds", strconv.FormatInt(int64(lease/time.Second), 10))
+	}
+
+	resp, err := h.Client.Get(withQuery(subscription.Callback, query))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, int64(len(challenge)+1)))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &sub.ResponseError{
+			Response: resp,
+			Message:  "Verification rejected",
+		}
+	}
+
+	if string(body) != challenge {
+		return ErrChallengeMismatch
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if mode == subscribeMode {
+		subscription.Expiry = h.now().Add(lease)
+
+		callbacks, ok := h.subs[subscription.Topic]
+		if !ok {
+			callbacks = map[string]*Subscription{}
+			h.subs[subscription.Topic] = callbacks
+		}
+
+		callbacks[subscription.Callback] = subscription
+	} else {
+		delete(h.subs[subscription.Topic], subscription.Callback)
+	}
+
+	return nil
+}
+
+// Tells a subscriber its subscription was denied.
+func (h *Hub) deny(subscription *Subscription, reason string) error {
+	query := url.Values{}
+	query.Set("hub.mode", deniedMode)
+	query.Set("hub.topic", subscription.Topic)
+	query.Set("hub.reason", reason)
+
+	resp, err := h.Client.Get(withQuery(subscription.Callback, query))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// Adds query to the query parameters already in rawURL.
+func withQuery(rawURL string, query url.Values) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	values := u.Query()
+	for key, vals := range query {
+		values[key] = vals
+	}
+
+	u.RawQuery = values.Encode()
+	return u.String()
+}
+
+// Accepts a publish notification then fetches the updated topics and
+// distributes them in the background. The topics may be in hub.url or
+// hub.topic. Topics without subscribers aren't fetched.
+func (h *Hub) handlePublish(rw http.ResponseWriter, r *http.Request) {
+	topics := append(r.PostForm["hub.url"], r.PostForm["hub.topic"]...)
+	if len(topics) == 0 {
+		http.Error(rw, "Missing hub.url", http.StatusBadRequest)
+		return
+	}
+
+	if h.ValidatePublish != nil {
+		err := h.ValidatePublish(r, topics)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+
+	for _, topic := range topics {
+		if len(h.Subscriptions(topic)) == 0 {
+			// nobody to deliver to, don't fetch arbitrary urls
+			continue
+		}
+
+		h.pending.Add(1)
+		go func(topic string) {
+			defer h.pending.Done()
+
+			err := h.fetchAndPublish(topic)
+			if err != nil {
+				h.handleError(err)
+			}
+		}(topic)
+	}
+}
+
+// Fetches the content of topic then distributes it.
+func (h *Hub) fetchAndPublish(topic string) error {
+	resp, err := h.Client.Get(topic)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &sub.ResponseError{
+			Response: resp,
+			Message:  "Fetching the topic failed",
+		}
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	return h.Publish(topic, resp.Header.Get("Content-Type"), body)
+}
+
+// Distributes content of topic to every subscriber with an active lease. The
+// deliveries are made concurrently. Every failure is sent to OnError and the
+// first one is returned.
+func (h *Hub) Publish(topic, contentType string, body []byte) error {
+	subscriptions := h.Subscriptions(topic)
+
+	var (
+		wg       sync.WaitGroup
+		errLock  sync.Mutex
+		firstErr error
+	)
+
+	for _, subscription := range subscriptions {
+		wg.Add(1)
+		go func(subscription Subscription) {
+			defer wg.Done()
+
+			err := h.deliver(&subscription, contentType, body)
+			if err == nil {
+				return
+			}
+
+			h.handleError(err)
+
+			errLock.Lock()
+			if firstErr == nil {
+				firstErr = err
+			}
+			errLock.Unlock()
+		}(subscription)
+	}
+
+	wg.Wait()
+	return firstErr
+}
+
+// Sends content to a single subscriber.
+func (h *Hub) deliver(subscription *Subscription, contentType string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, subscription.Callback,
+		bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	if h.URL != nil {
+		req.Header.Add("Link", "<"+h.URL.String()+`>; rel="hub"`)
+	}
+	req.Header.Add("Link", "<"+subscription.Topic+`>; rel="self"`)
+
+	if len(subscription.Secret) > 0 {
+		req.Header.Set("X-Hub-Signature",
+			h.SignatureAlgorithm.Sign(subscription.Secret, body))
+	}
+
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%w: %s: %s", ErrDeliveryFailed,
+			subscription.Topic, resp.Status)
+	}
+
+	return nil
+}
+
+// Returns a snapshot of the subscriptions to topic with active leases.
+func (h *Hub) Subscriptions(topic string) []Subscription {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	now := h.now()
+	subscriptions := []Subscription{}
+	for callback, subscription := range h.subs[topic] {
+		if now.After(subscription.Expiry) {
+			// lapsed
+			delete(h.subs[topic], callback)
+			continue
+		}
+
+		subscriptions = append(subscriptions, *subscription)
+	}
+
+	return subscriptions
+}
+
+// Ends every subscription to topic without telling the subscribers, as if
+// their leases lapsed.
+func (h *Hub) Expire(topic string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	delete(h.subs, topic)
+}
+
+// Waits for the verifications and publishes accepted by ServeHTTP to finish.
+func (h *Hub) Wait() {
+	h.pending.Wait()
+}
+
+func (h *Hub) now() time.Time {
+	if h.Now != nil {
+		return h.Now()
+	}
+
+	return time.Now()
+}
+
+func (h *Hub) handleError(err error) {
+	if h.OnError != nil {
+		h.OnError(err)
+	}
+}
@@ -0,0 +1,259 @@
+package hub
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0xcaff/sub"
+)
+
+func MustParseUrl(raw string) *url.URL {
+	u, err := url.Parse(raw)
+	if err != nil {
+		panic(err)
+	}
+
+	return u
+}
+
+// Starts a hub and a subscriber to topic which sends messages to received.
+func setup(t *testing.T, topic string) (*Hub, *httptest.Server, *sub.Sub, *httptest.Server, chan []byte) {
+	h := New()
+	h.OnError = func(err error) { t.Error(err) }
+	hubServer := httptest.NewServer(h)
+
+	received := make(chan []byte, 1)
+	s := sub.New()
+	s.Topic = MustParseUrl(topic)
+	s.Hub = MustParseUrl(hubServer.URL)
+	s.OnError = func(err error) { t.Error(err) }
+	s.OnMessage = func(r *http.Request, body []byte) {
+		received <- body
+	}
+
+	subServer := httptest.NewServer(s)
+	s.Callback = MustParseUrl(subServer.URL + "/callback?id=1")
+
+	return h, hubServer, s, subServer, received
+}
+
+func TestSubscribeAndPublish(t *testing.T) {
+	topic := "https://example.com/feed"
+	h, hubServer, s, subServer, received := setup(t, topic)
+	defer hubServer.Close()
+	defer subServer.Close()
+	defer s.CancelRenewal()
+
+	err := s.SubscribeWithLease(3600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Wait()
+
+	if s.State() != sub.Subscribed {
+		t.Fatal("Expected the subscription to be verified, got", s.State())
+	}
+
+	lease := s.LeaseExpiry().Sub(time.Now())
+	if lease < 3590*time.Second || lease > 3600*time.Second {
+		t.Error("Unexpected lease", lease)
+	}
+
+	subscriptions := h.Subscriptions(topic)
+	if len(subscriptions) != 1 || subscriptions[0].Callback != s.Callback.String() {
+		t.Fatal("Unexpected subscriptions", subscriptions)
+	}
+
+	err = h.Publish(topic, "text/plain", []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if body := <-received; string(body) != "hello" {
+		t.Error("Received", string(body))
+	}
+
+	// other topics aren't delivered
+	err = h.Publish("https://example.com/other", "text/plain", []byte("other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Unsubscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Wait()
+
+	if s.State() != sub.Unsubscribed {
+		t.Error("Expected the unsubscription to be verified, got", s.State())
+	}
+
+	if len(h.Subscriptions(topic)) != 0 {
+		t.Error("The subscription should have been removed")
+	}
+}
+
+func TestPublishNotification(t *testing.T) {
+	content := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/atom+xml")
+		w.Write([]byte("<feed/>"))
+	}))
+	defer content.Close()
+
+	h, hubServer, s, subServer, received := setup(t, content.URL)
+	defer hubServer.Close()
+	defer subServer.Close()
+	defer s.CancelRenewal()
+
+	err := s.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Wait()
+
+	resp, err := http.PostForm(hubServer.URL, url.Values{
+		"hub.mode": {"publish"},
+		"hub.url":  {content.URL},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusNoContent {
+		t.Error("Unexpected status", resp.Status)
+	}
+
+	if body := <-received; string(body) != "<feed/>" {
+		t.Error("Received", string(body))
+	}
+}
+
+func TestPublishValidation(t *testing.T) {
+	fetched := make(chan string, 2)
+	content := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched <- r.URL.Path
+		w.Write([]byte("<feed/>"))
+	}))
+	defer content.Close()
+
+	topic := content.URL + "/feed"
+	h, hubServer, s, subServer, received := setup(t, topic)
+	defer hubServer.Close()
+	defer subServer.Close()
+	defer s.CancelRenewal()
+
+	err := s.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Wait()
+
+	h.ValidatePublish = func(r *http.Request, topics []string) error {
+		if r.Header.Get("Authorization") != "Bearer publisher" {
+			return errors.New("unknown publisher")
+		}
+
+		return nil
+	}
+
+	publish := func(authorization string, topics ...string) int {
+		req, err := http.NewRequest(http.MethodPost, hubServer.URL, strings.NewReader(
+			url.Values{"hub.mode": {"publish"}, "hub.url": topics}.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", authorization)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		h.Wait()
+
+		return resp.StatusCode
+	}
+
+	if code := publish("", topic); code != http.StatusForbidden {
+		t.Error("Expected an unknown publisher to be rejected, got", code)
+	}
+
+	// topics without subscribers aren't fetched
+	if code := publish("Bearer publisher", topic, content.URL+"/other"); code != http.StatusNoContent {
+		t.Error("Unexpected status", code)
+	}
+
+	if path := <-fetched; path != "/feed" {
+		t.Error("Fetched", path)
+	}
+
+	if body := <-received; string(body) != "<feed/>" {
+		t.Error("Received", string(body))
+	}
+
+	select {
+	case path := <-fetched:
+		t.Error("Fetched", path)
+	default:
+	}
+}
+
+func TestDeny(t *testing.T) {
+	topic := "https://example.com/feed"
+	h, hubServer, s, subServer, _ := setup(t, topic)
+	defer hubServer.Close()
+	defer subServer.Close()
+
+	var denied error
+	s.OnError = func(err error) { denied = err }
+	h.Validate = func(topic, callback string) error {
+		return ErrChallengeMismatch
+	}
+
+	err := s.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Wait()
+
+	deniedErr, ok := denied.(*sub.DeniedError)
+	if !ok || deniedErr.Reason != ErrChallengeMismatch.Error() {
+		t.Error("Expected a DeniedError, got", denied)
+	}
+
+	if len(h.Subscriptions(topic)) != 0 {
+		t.Error("A denied subscription shouldn't be active")
+	}
+}
+
+func TestInvalidRequests(t *testing.T) {
+	h := New()
+
+	tests := []url.Values{
+		{"hub.mode": {"subscribe"}, "hub.callback": {"https://me/"}},
+		{"hub.mode": {"subscribe"}, "hub.topic": {"t"}, "hub.callback": {"/relative"}},
+		{"hub.mode": {"subscribe"}, "hub.topic": {"t"}, "hub.callback": {"https://me/"},
+			"hub.lease_seconds": {"-1"}},
+		{"hub.mode": {"publish"}},
+		{"hub.mode": {"unknown"}},
+	}
+
+	for _, values := range tests {
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/",
+			nil)
+		req.PostForm = values
+
+		h.ServeHTTP(rw, req)
+		if rw.Code != http.StatusBadRequest {
+			t.Error(values, "expected bad request, got", rw.Code)
+		}
+	}
+}
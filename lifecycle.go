@@ -0,0 +1,121 @@
+package sub
+
+import (
+	"context"
+	"errors"
+)
+
+// Returned when starting a subscription which was closed.
+var ErrClosed = errors.New("subscription closed")
+
+// Starts the subscription by subscribing to the hub. Renewals are scheduled
+// once the hub verifies the subscription.
+func (s *Sub) Start(ctx context.Context) error {
+	if s.isClosing() {
+		return ErrClosed
+	}
+
+	return s.SubscribeContext(ctx)
+}
+
+// Stops the subscription. Pending renewals are cancelled, the request of a
+// renewal in progress is aborted and, if UnsubscribeOnClose is set, the
+// subscription is cancelled with the hub. Then it waits for callbacks in
+// progress to finish. Messages arriving
+// after Close was called are rejected so the hub retries them later.
+//
+// Close is safe to call concurrently and repeatedly. Later calls wait for the
+// first to finish and return its error. If ctx is done first, its error is
+// returned. Calling Close from a callback of the subscription waits until ctx
+// is done.
+func (s *Sub) Close(ctx context.Context) error {
+	s.lifecycleLock.Lock()
+	done := s.doneLocked()
+	first := !s.closing
+	s.closing = true
+	s.lifecycleLock.Unlock()
+
+	if !first {
+		select {
+		case <-done:
+			return s.closeErr
+
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	// also aborts the request of a renewal in progress
+	s.CancelRenewal()
+
+	var err error
+	if s.UnsubscribeOnClose && s.State() != Unsubscribed && s.activeHub() != nil {
+		err = s.UnsubscribeContext(ctx)
+	}
+
+	// wait for work in progress
+	idle := make(chan struct{})
+	go func() {
+		s.inflight.Wait()
+		close(idle)
+	}()
+
+	select {
+	case <-idle:
+
+	case <-ctx.Done():
+		if err == nil {
+			err = ctx.Err()
+		}
+	}
+
+	s.closeErr = err
+	close(done)
+
+	return err
+}
+
+// Returns a channel which is closed once Close finished.
+func (s *Sub) Done() <-chan struct{} {
+	s.lifecycleLock.Lock()
+	defer s.lifecycleLock.Unlock()
+
+	return s.doneLocked()
+}
+
+// Returns the done channel, creating it if needed. The lifecycleLock must be
+// held.
+func (s *Sub) doneLocked() chan struct{} {
+	if s.done == nil {
+		s.done = make(chan struct{})
+	}
+
+	return s.done
+}
+
+// Returns true once Close was called.
+func (s *Sub) isClosing() bool {
+	s.lifecycleLock.Lock()
+	defer s.lifecycleLock.Unlock()
+
+	return s.closing
+}
+
+// Records that work which Close must wait for started. Returns false, without
+// recording anything, when the subscription is closing.
+func (s *Sub) track() bool {
+	s.lifecycleLock.Lock()
+	defer s.lifecycleLock.Unlock()
+
+	if s.closing {
+		return false
+	}
+
+	s.inflight.Add(1)
+	return true
+}
+
+// Records that work started with track finished.
+func (s *Sub) untrack() {
+	s.inflight.Done()
+}
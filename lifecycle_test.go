@@ -0,0 +1,168 @@
+package sub
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCloseRepeatedly(t *testing.T) {
+	clock := newFakeClock()
+	s := &Sub{
+		Clock: clock,
+		OnRenewLease: func(s *Sub) {
+			t.Error("The lease shouldn't have been renewed after closing")
+		},
+		leaseExpiry: clock.Now().Add(time.Hour),
+	}
+	s.scheduleRenewal()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := s.Close(context.Background())
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	select {
+	case <-s.Done():
+	default:
+		t.Error("Done should be closed")
+	}
+
+	// renewals aren't scheduled anymore
+	s.scheduleRenewal()
+	clock.Advance(time.Hour)
+
+	err := s.Start(context.Background())
+	if err != ErrClosed {
+		t.Error("Expected ErrClosed, got", err)
+	}
+}
+
+func TestCloseWaitsForRenewal(t *testing.T) {
+	clock := newFakeClock()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s := &Sub{
+		Clock: clock,
+		OnRenewLease: func(s *Sub) {
+			close(started)
+			<-release
+		},
+		leaseExpiry: clock.Now().Add(time.Hour),
+	}
+	s.scheduleRenewal()
+
+	go clock.Advance(time.Hour)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	err := s.Close(ctx)
+	if err != context.DeadlineExceeded {
+		t.Error("Expected Close to wait for the renewal, got", err)
+	}
+
+	close(release)
+
+	// later calls report the error of the first
+	err = s.Close(context.Background())
+	if err != context.DeadlineExceeded {
+		t.Error("Expected the first error, got", err)
+	}
+}
+
+func TestCloseAbortsRenewal(t *testing.T) {
+	started := make(chan struct{})
+	aborted := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a hub which never answers, the request is cancelled once the
+		// connection is closed after the body was read
+		ioutil.ReadAll(r.Body)
+		close(started)
+		<-r.Context().Done()
+		close(aborted)
+	}))
+	defer ts.Close()
+
+	clock := newFakeClock()
+	s := &Sub{
+		Hub:         MustParseUrl(ts.URL),
+		Topic:       MustParseUrl("https://example.com/feed.xml"),
+		Callback:    MustParseUrl("https://my-server.com/subscriber"),
+		Client:      http.DefaultClient,
+		Clock:       clock,
+		state:       Subscribed,
+		leaseExpiry: clock.Now().Add(time.Hour),
+		OnError: func(err error) {
+			t.Error("An aborted renewal shouldn't be reported, got", err)
+		},
+	}
+	s.scheduleRenewal()
+
+	go clock.Advance(time.Hour)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := s.Close(ctx)
+	if err != nil {
+		t.Error("Expected the renewal to be aborted, got", err)
+	}
+
+	<-aborted
+}
+
+func TestCloseUnsubscribes(t *testing.T) {
+	modes := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		modes <- r.PostForm.Get("hub.mode")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	s := &Sub{
+		Hub:                MustParseUrl(ts.URL),
+		Topic:              MustParseUrl("https://example.com/feed.xml"),
+		Callback:           MustParseUrl("https://my-server.com/subscriber"),
+		Client:             http.DefaultClient,
+		state:              Subscribed,
+		UnsubscribeOnClose: true,
+		OnMessage: func(r *http.Request, body []byte) {
+			t.Error("Messages shouldn't be delivered after closing")
+		},
+	}
+
+	err := s.Close(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mode := <-modes; mode != unsubscribeMode {
+		t.Error("Expected an unsubscription, got", mode)
+	}
+
+	rw := httptest.NewRecorder()
+	s.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/",
+		strings.NewReader(url.Values{}.Encode())))
+	if rw.Code != http.StatusServiceUnavailable {
+		t.Error("Expected messages to be rejected, got", rw.Code)
+	}
+}
@@ -0,0 +1,212 @@
+package sub
+
+import (
+	"net/url"
+	"strings"
+)
+
+// A single link parsed from a Link header.
+type link struct {
+	// The target of the link as it appeared in the header. It may be relative.
+	Target string
+
+	// The lower cased link relation types.
+	Rels []string
+}
+
+// Returns true if rel is one of the link's relation types.
+func (l *link) hasRel(rel string) bool {
+	for _, r := range l.Rels {
+		if r == rel {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Resolves the target of the link against base.
+func (l *link) resolve(base *url.URL) (*url.URL, error) {
+	target, err := url.Parse(l.Target)
+	if err != nil {
+		return nil, err
+	}
+
+	if base == nil {
+		return target, nil
+	}
+
+	return base.ResolveReference(target), nil
+}
+
+// Parses the values of one or more Link headers as described in RFC 8288.
+// Each header may contain multiple comma separated links. Malformed links are
+// skipped.
+func parseLinkHeader(values []string) []link {
+	links := []link{}
+
+	for _, value := range values {
+		p := &linkParser{input: value}
+		for !p.done() {
+			l, ok := p.parseLink()
+			if ok {
+				links = append(links, l)
+			}
+		}
+	}
+
+	return links
+}
+
+// A small scanner over a single Link header value.
+type linkParser struct {
+	input string
+	pos   int
+}
+
+func (p *linkParser) done() bool {
+	return p.pos >= len(p.input)
+}
+
+func (p *linkParser) peek() byte {
+	if p.done() {
+		return 0
+	}
+
+	return p.input[p.pos]
+}
+
+func (p *linkParser) skipSpace() {
+	for !p.done() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.pos++
+	}
+}
+
+// Skips to the start of the next link in the value.
+func (p *linkParser) skipLink() {
+	for !p.done() {
+		switch p.peek() {
+		case '"':
+			p.parseQuoted()
+			continue
+
+		case ',':
+			p.pos++
+			return
+		}
+
+		p.pos++
+	}
+}
+
+// Parses a link-value. The parser is always advanced past the link, even when
+// it is malformed.
+func (p *linkParser) parseLink() (link, bool) {
+	l := link{}
+
+	p.skipSpace()
+	if p.peek() == ',' {
+		// empty element
+		p.pos++
+		return l, false
+	}
+
+	if p.peek() != '<' {
+		p.skipLink()
+		return l, false
+	}
+
+	end := strings.IndexByte(p.input[p.pos:], '>')
+	if end < 0 {
+		p.pos = len(p.input)
+		return l, false
+	}
+
+	l.Target = strings.TrimSpace(p.input[p.pos+1 : p.pos+end])
+	p.pos += end + 1
+
+	// parse link-params
+	for {
+		p.skipSpace()
+		if p.done() {
+			break
+		}
+
+		if p.peek() == ',' {
+			p.pos++
+			break
+		}
+
+		if p.peek() != ';' {
+			p.skipLink()
+			return l, false
+		}
+		p.pos++
+
+		name, value := p.parseParam()
+		if name == "rel" && l.Rels == nil {
+			// only the first rel parameter is significant
+			l.Rels = strings.Fields(strings.ToLower(value))
+		}
+	}
+
+	return l, len(l.Rels) > 0
+}
+
+// Parses a single link-param after the leading semicolon.
+func (p *linkParser) parseParam() (string, string) {
+	p.skipSpace()
+
+	start := p.pos
+	for !p.done() && !strings.ContainsRune("=;, \t", rune(p.peek())) {
+		p.pos++
+	}
+	name := strings.ToLower(p.input[start:p.pos])
+
+	p.skipSpace()
+	if p.peek() != '=' {
+		return name, ""
+	}
+	p.pos++
+	p.skipSpace()
+
+	if p.peek() == '"' {
+		return name, p.parseQuoted()
+	}
+
+	start = p.pos
+	for !p.done() && !strings.ContainsRune(";, \t", rune(p.peek())) {
+		p.pos++
+	}
+
+	return name, p.input[start:p.pos]
+}
+
+// Parses a quoted-string starting at the opening quote, handling backslash
+// escapes.
+func (p *linkParser) parseQuoted() string {
+	// skip opening quote
+	p.pos++
+
+	var value strings.Builder
+	for !p.done() {
+		c := p.peek()
+		p.pos++
+
+		switch c {
+		case '\\':
+			if !p.done() {
+				value.WriteByte(p.peek())
+				p.pos++
+			}
+
+		case '"':
+			return value.String()
+
+		default:
+			value.WriteByte(c)
+		}
+	}
+
+	return value.String()
+}
@@ -0,0 +1,68 @@
+package sub
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLinkHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		want   []link
+	}{
+		{
+			name:   "single",
+			values: []string{"<https://hub.example.com/>; rel=hub"},
+			want:   []link{{"https://hub.example.com/", []string{"hub"}}},
+		},
+		{
+			name: "multiple headers",
+			values: []string{
+				`<https://hub.example.com/>; rel="hub"`,
+				`<https://example.com/feed>; rel="self"`,
+			},
+			want: []link{
+				{"https://hub.example.com/", []string{"hub"}},
+				{"https://example.com/feed", []string{"self"}},
+			},
+		},
+		{
+			name: "multiple values",
+			values: []string{
+				`<https://a.example.com/>; rel="hub", <https://b.example.com/>; rel=hub`,
+			},
+			want: []link{
+				{"https://a.example.com/", []string{"hub"}},
+				{"https://b.example.com/", []string{"hub"}},
+			},
+		},
+		{
+			name:   "quoted rel list",
+			values: []string{`<https://example.com/>; title="a, b; c"; rel="Self  HUB"`},
+			want:   []link{{"https://example.com/", []string{"self", "hub"}}},
+		},
+		{
+			name:   "first rel wins",
+			values: []string{`<https://example.com/>; rel=hub; rel=self`},
+			want:   []link{{"https://example.com/", []string{"hub"}}},
+		},
+		{
+			name:   "no rel",
+			values: []string{`<https://example.com/>; title=feed, <https://hub/>;rel=hub`},
+			want:   []link{{"https://hub/", []string{"hub"}}},
+		},
+		{
+			name:   "malformed",
+			values: []string{`https://example.com/; rel=hub, <https://hub/`},
+			want:   []link{},
+		},
+	}
+
+	for _, test := range tests {
+		got := parseLinkHeader(test.values)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %#v, expected %#v", test.name, got, test.want)
+		}
+	}
+}
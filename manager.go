@@ -0,0 +1,320 @@
+package sub
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Returned by Manager.Add when a subscription with the same name exists.
+var ErrDuplicateName = errors.New("duplicate subscription name")
+
+// Manages many subscriptions served by a single http.Handler. Each
+// subscription is identified by a name and receives an unguessable callback
+// url under BaseURL.
+type Manager struct {
+	// The publicly accessible url which callback urls are allocated under.
+	// Requests to the manager must have the same path as the callback urls.
+	BaseURL *url.URL
+
+	// When set, subscriptions are saved in the store under their names and
+	// restored from it when added.
+	Store Store
+
+	lock sync.RWMutex
+
+	// subscriptions by name
+	subs map[string]*Sub
+
+	// subscriptions by the path of their callback url
+	paths map[string]*Sub
+
+	// the paths of removed subscriptions which are still routed so the hub
+	// can verify their unsubscription
+	removing map[string]bool
+}
+
+func NewManager(baseURL *url.URL) *Manager {
+	return &Manager{
+		BaseURL:  baseURL,
+		subs:     map[string]*Sub{},
+		paths:    map[string]*Sub{},
+		removing: map[string]bool{},
+	}
+}
+
+// The length of the random part of allocated callback paths.
+const callbackPathLen = 99
+
+// Adds a subscription to the manager and starts routing its callbacks.
+//
+// If the manager has a store with a record of a subscription with the same
+// name, topic and hub, the subscription is restored from it. Otherwise, if s
+// has no callback url, a random one is allocated under BaseURL.
+func (m *Manager) Add(name string, s *Sub) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.subs[name]; ok {
+		return ErrDuplicateName
+	}
+
+	restored := false
+	if m.Store != nil {
+		var err error
+		restored, err = m.restore(name, s)
+		if err != nil {
+			return err
+		}
+
+		s.Store = m.Store
+		s.StoreKey = name
+	}
+
+	if !restored && s.Callback == nil {
+		s.Callback = m.allocateCallback()
+	}
+
+	if _, ok := m.paths[s.Callback.Path]; ok {
+		return ErrDuplicateName
+	}
+
+	m.subs[name] = s
+	m.paths[s.Callback.Path] = s
+
+	return nil
+}
+
+// Returns a random callback url under BaseURL.
+func (m *Manager) allocateCallback() *url.URL {
+	callback := *m.BaseURL
+	callback.Path = strings.TrimSuffix(callback.Path, "/") + "/" +
+		string(RandAlphanumBytes(callbackPathLen))
+	callback.RawPath = ""
+
+	return &callback
+}
+
+// Restores s from the record stored under name if the record is of the same
+// topic and hub and its callback is under BaseURL.
+func (m *Manager) restore(name string, s *Sub) (bool, error) {
+	record, err := m.Store.Get(name)
+	if err == ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	topic := s.Topic.String()
+	if record.Topic != topic && record.OriginalTopic != topic {
+		return false, nil
+	}
+
+	if s.Hub != nil && record.Hub != s.Hub.String() {
+		return false, nil
+	}
+
+	if !strings.HasPrefix(record.Callback, m.BaseURL.String()) {
+		return false, nil
+	}
+
+	err = s.Restore(record)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Stops managing the subscription with name. The subscription is cancelled
+// with the hub, its renewal is cancelled and it is removed from the store.
+// Its callback keeps being routed until the hub verified the unsubscription
+// or the request timed out, see Sub.PendingTimeout.
+func (m *Manager) Remove(ctx context.Context, name string) error {
+	m.lock.Lock()
+	s, ok := m.subs[name]
+	if ok {
+		delete(m.subs, name)
+		m.removing[s.Callback.Path] = true
+	}
+	m.lock.Unlock()
+
+	if !ok {
+		return ErrNotFound
+	}
+
+	s.CancelRenewal()
+
+	if s.State() != Unsubscribed && s.activeHub() != nil {
+		err := s.UnsubscribeContext(ctx)
+		if err != nil {
+			m.stopRouting(s)
+			return err
+		}
+
+		// give up on the verification eventually
+		s.clock().AfterFunc(s.pendingTimeout(), func() { m.stopRouting(s) })
+	} else {
+		m.stopRouting(s)
+	}
+
+	if m.Store != nil {
+		return m.Store.Delete(name)
+	}
+
+	return nil
+}
+
+// Returns the subscription with name.
+func (m *Manager) Get(name string) (*Sub, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	s, ok := m.subs[name]
+	return s, ok
+}
+
+// Returns the sorted names of every managed subscription.
+func (m *Manager) List() []string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	names := make([]string, 0, len(m.subs))
+	for name := range m.subs {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// Subscribes every subscription which isn't active or whose lease expires
+// within margin. It stops at the first failure.
+func (m *Manager) SubscribeAll(ctx context.Context, margin time.Duration) error {
+	for _, name := range m.List() {
+		s, ok := m.Get(name)
+		if !ok || !s.NeedsRenewal(margin) {
+			continue
+		}
+
+		err := s.SubscribeContext(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Unsubscribes every subscription concurrently. Errors are sent to the
+// OnError of each subscription and the first error is returned.
+func (m *Manager) UnsubscribeAll(ctx context.Context) error {
+	var (
+		wg       sync.WaitGroup
+		errLock  sync.Mutex
+		firstErr error
+	)
+
+	for _, name := range m.List() {
+		s, ok := m.Get(name)
+		if !ok {
+			continue
+		}
+
+		wg.Add(1)
+		go func(s *Sub) {
+			defer wg.Done()
+
+			err := s.UnsubscribeContext(ctx)
+			if err == nil {
+				return
+			}
+
+			if s.OnError != nil {
+				s.OnError(err)
+			}
+
+			errLock.Lock()
+			if firstErr == nil {
+				firstErr = err
+			}
+			errLock.Unlock()
+		}(s)
+	}
+
+	wg.Wait()
+	return firstErr
+}
+
+// Closes every subscription concurrently, see Sub.Close. The first error is
+// returned.
+func (m *Manager) Close(ctx context.Context) error {
+	var (
+		wg       sync.WaitGroup
+		errLock  sync.Mutex
+		firstErr error
+	)
+
+	for _, name := range m.List() {
+		s, ok := m.Get(name)
+		if !ok {
+			continue
+		}
+
+		wg.Add(1)
+		go func(s *Sub) {
+			defer wg.Done()
+
+			err := s.Close(ctx)
+			if err == nil {
+				return
+			}
+
+			errLock.Lock()
+			if firstErr == nil {
+				firstErr = err
+			}
+			errLock.Unlock()
+		}(s)
+	}
+
+	wg.Wait()
+	return firstErr
+}
+
+// Implements http.Handler by routing requests to the subscription with a
+// matching callback path.
+func (m *Manager) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	m.lock.RLock()
+	s, ok := m.paths[r.URL.Path]
+	m.lock.RUnlock()
+
+	if !ok {
+		http.NotFound(rw, r)
+		return
+	}
+
+	s.ServeHTTP(rw, r)
+
+	if s.State() == Unsubscribed {
+		// the unsubscription of a removed subscription was verified
+		m.stopRouting(s)
+	}
+}
+
+// Stops routing the callback of a removed subscription.
+func (m *Manager) stopRouting(s *Sub) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	path := s.Callback.Path
+	if m.removing[path] && m.paths[path] == s {
+		delete(m.paths, path)
+		delete(m.removing, path)
+	}
+}
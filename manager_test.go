@@ -0,0 +1,210 @@
+package sub
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestManagerRouting(t *testing.T) {
+	m := NewManager(MustParseUrl("https://me.example.com/subs"))
+
+	a := &Sub{Topic: MustParseUrl("https://example.com/a")}
+	a.request(subscribeMode, 0)
+	b := &Sub{Topic: MustParseUrl("https://example.com/b")}
+	b.request(unsubscribeMode, 0)
+
+	for name, s := range map[string]*Sub{"a": a, "b": b} {
+		err := m.Add(name, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.HasPrefix(s.Callback.String(), "https://me.example.com/subs/") {
+			t.Error("Callback not allocated under the base url", s.Callback)
+		}
+	}
+
+	if a.Callback.String() == b.Callback.String() {
+		t.Error("Callbacks should be unique")
+	}
+
+	err := m.Add("a", &Sub{})
+	if err != ErrDuplicateName {
+		t.Error("Expected ErrDuplicateName, got", err)
+	}
+
+	if !reflect.DeepEqual(m.List(), []string{"a", "b"}) {
+		t.Error("Unexpected names", m.List())
+	}
+
+	if got, ok := m.Get("b"); !ok || got != b {
+		t.Error("Get returned the wrong subscription")
+	}
+
+	// verify b through the manager
+	query := url.Values{}
+	query.Set("hub.topic", b.Topic.String())
+	query.Set("hub.mode", unsubscribeMode)
+	query.Set("hub.challenge", "challenge")
+
+	rw := httptest.NewRecorder()
+	m.ServeHTTP(rw, httptest.NewRequest(http.MethodGet,
+		b.Callback.Path+"?"+query.Encode(), nil))
+
+	if rw.Code != http.StatusOK || rw.Body.String() != "challenge" {
+		t.Error("Unexpected response", rw.Code, rw.Body.String())
+	}
+
+	if b.State() != Unsubscribed || a.State() != Requested {
+		t.Error("The request was routed to the wrong subscription")
+	}
+
+	rw = httptest.NewRecorder()
+	m.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/subs/unknown", nil))
+	if rw.Code != http.StatusNotFound {
+		t.Error("Expected unknown paths to be not found, got", rw.Code)
+	}
+}
+
+func TestManagerRestore(t *testing.T) {
+	path, cleanup := tempStorePath(t)
+	defer cleanup()
+
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record := &Record{
+		Topic:         "https://example.com/canonical",
+		OriginalTopic: "https://example.com/feed",
+		Hub:           "https://hub.example.com/",
+		Callback:      "https://me.example.com/subs/abc",
+		Secret:        "secret",
+		State:         Subscribed,
+		LeaseExpiry:   time.Now().Add(time.Hour),
+	}
+
+	err = store.Put("feed", record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewManager(MustParseUrl("https://me.example.com/subs/"))
+	m.Store = store
+
+	s := &Sub{Topic: MustParseUrl("https://example.com/feed")}
+	err = m.Add("feed", s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.CancelRenewal()
+
+	if s.Callback.String() != record.Callback || string(s.Secret) != "secret" {
+		t.Error("The subscription wasn't restored", s.Record())
+	}
+
+	if s.NeedsRenewal(time.Minute) {
+		t.Error("The restored subscription shouldn't need renewal")
+	}
+
+	// a record for another hub isn't restored
+	other := &Sub{
+		Topic: MustParseUrl("https://example.com/feed"),
+		Hub:   MustParseUrl("https://other-hub.example.com/"),
+	}
+
+	err = m.Add("other", other)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if other.Callback.String() == record.Callback {
+		t.Error("The record shouldn't have been restored for another hub")
+	}
+}
+
+func TestManagerRemove(t *testing.T) {
+	m := NewManager(nil)
+	callbacks := httptest.NewServer(m)
+	defer callbacks.Close()
+	m.BaseURL = MustParseUrl(callbacks.URL + "/subs")
+
+	// a hub which verifies unsubscriptions and reports the responses
+	verifications := make(chan string, 1)
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("hub.mode") != unsubscribeMode {
+			t.Error("Expected an unsubscription, got", r.Form.Get("hub.mode"))
+		}
+
+		query := url.Values{}
+		query.Set("hub.mode", unsubscribeMode)
+		query.Set("hub.topic", r.Form.Get("hub.topic"))
+		query.Set("hub.challenge", "challenge")
+		callback := r.Form.Get("hub.callback") + "?" + query.Encode()
+
+		w.WriteHeader(http.StatusAccepted)
+
+		go func() {
+			resp, err := http.Get(callback)
+			if err != nil {
+				verifications <- err.Error()
+				return
+			}
+			defer resp.Body.Close()
+
+			body, _ := ioutil.ReadAll(resp.Body)
+			verifications <- resp.Status + " " + string(body)
+		}()
+	}))
+	defer hub.Close()
+
+	s := &Sub{
+		Topic:  MustParseUrl("https://example.com/feed"),
+		Hub:    MustParseUrl(hub.URL),
+		Client: http.DefaultClient,
+		state:  Subscribed,
+	}
+
+	err := m.Add("feed", s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Remove(context.Background(), "feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := <-verifications; got != "200 OK challenge" {
+		t.Error("Expected the unsubscription to be verified, got", got)
+	}
+
+	if s.State() != Unsubscribed {
+		t.Error("Expected the subscription to be unsubscribed, got", s.State())
+	}
+
+	if _, ok := m.Get("feed"); ok {
+		t.Error("The subscription should have been removed")
+	}
+
+	// the callback isn't routed after the verification
+	rw := httptest.NewRecorder()
+	m.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, s.Callback.Path, nil))
+	if rw.Code != http.StatusNotFound {
+		t.Error("Expected the callback to be gone, got", rw.Code)
+	}
+
+	err = m.Remove(context.Background(), "feed")
+	if err != ErrNotFound {
+		t.Error("Expected ErrNotFound, got", err)
+	}
+}
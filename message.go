@@ -0,0 +1,288 @@
+package sub
+
+import (
+	"crypto/hmac"
+	"errors"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// The default of Sub.MaxBodySize.
+const defaultMaxBodySize = 10 << 20
+
+// A message was larger than Sub.MaxBodySize.
+var ErrBodyTooLarge = errors.New("message body too large")
+
+// Handles a message from the hub. Messages larger than MaxBodySize are
+// rejected with 413 and messages with malformed signatures with 400. As the
+// protocol requires, messages with missing or wrong signatures are
+// acknowledged without being passed on.
+func (s *Sub) handleMessage(rw http.ResponseWriter, r *http.Request) {
+	if s.OnMessage == nil && s.OnMessageStream == nil && s.OnEntries == nil {
+		// nothing to do
+		return
+	}
+
+	if !s.track() {
+		// closed, the hub retries the delivery later
+		http.Error(rw, "Subscription closed", http.StatusServiceUnavailable)
+		return
+	}
+	defer s.untrack()
+
+	maxSize := s.maxBodySize()
+	if maxSize >= 0 && r.ContentLength > maxSize {
+		s.handleError(r, ErrBodyTooLarge)
+
+		http.Error(rw, "Message too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	body, err := s.messageBody(r, maxSize)
+	if err == ErrMissingSignature || err == ErrNoSecret {
+		s.handleError(r, err)
+
+		// acknowledge but ignore
+		rw.WriteHeader(http.StatusOK)
+		return
+	} else if err != nil {
+		s.handleError(r, err)
+
+		http.Error(rw, "X-Hub-Signature header is invalid", http.StatusBadRequest)
+		return
+	}
+
+	var handlerErr error
+	if s.OnMessageStream != nil {
+		handlerErr = s.OnMessageStream(r, body)
+
+		// verify whatever the handler didn't read
+		_, err = io.Copy(ioutil.Discard, body)
+	} else {
+		var message []byte
+		message, err = ioutil.ReadAll(body)
+		if err == nil {
+			handlerErr = s.deliverNotification(r, message)
+		}
+	}
+
+	switch {
+	case err == ErrBodyTooLarge:
+		s.handleError(r, err)
+
+		http.Error(rw, "Message too large", http.StatusRequestEntityTooLarge)
+
+	case err == ErrBadSignature:
+		// invalid hmac signature
+		s.handleError(r, err)
+
+		rw.WriteHeader(http.StatusOK)
+
+	case err != nil:
+		s.handleError(r, err)
+
+		// unable to read stream
+		http.Error(rw, "", http.StatusInternalServerError)
+
+	case handlerErr != nil:
+		s.handleError(r, handlerErr)
+
+		// the hub delivers the message again later
+		http.Error(rw, "", http.StatusInternalServerError)
+
+	default:
+		rw.WriteHeader(http.StatusOK)
+	}
+}
+
+// Delivers an authentic message. With FetchThinPings, the topic is fetched
+// for messages without content and delivered instead if it changed.
+func (s *Sub) deliverNotification(r *http.Request, message []byte) error {
+	contentType := r.Header.Get("Content-Type")
+	if s.FetchThinPings && isThinPing(message) {
+		var changed bool
+		var err error
+		contentType, message, changed, err = s.fetchTopic(r.Context())
+		if err != nil {
+			return err
+		}
+
+		if !changed {
+			// nothing new
+			return nil
+		}
+	}
+
+	s.deliverMessage(r, contentType, message)
+	return nil
+}
+
+// Passes a message to OnEntries when it is a feed. Other messages are passed
+// to OnMessage. With Dedup, entries and messages which were delivered before
+// are dropped.
+func (s *Sub) deliverMessage(r *http.Request, contentType string, message []byte) {
+	var entries []Entry
+	isFeed := false
+	if s.OnEntries != nil || s.Dedup != nil {
+		var err error
+		entries, err = ParseFeed(contentType, message)
+		if err == nil {
+			isFeed = true
+		} else if err != ErrUnknownFeedType {
+			s.handleError(r, err)
+		}
+	}
+
+	if s.Dedup != nil && (isFeed || !s.Dedup.EntriesOnly) {
+		if isFeed {
+			entries = s.Dedup.newEntries(entries)
+			if len(entries) == 0 {
+				// nothing new
+				return
+			}
+		} else if !s.Dedup.Add(bodyKey(message)) {
+			// delivered before
+			return
+		}
+
+		// the window is persisted with the subscription
+		s.save()
+	}
+
+	if isFeed && s.OnEntries != nil {
+		s.OnEntries(r, entries)
+		return
+	}
+
+	if s.OnMessage != nil {
+		s.OnMessage(r, message)
+	}
+}
+
+// Returns a reader of the body of a message which checks its signature. The
+// body isn't checked when the subscription has no secret and AllowUnsigned is
+// set.
+func (s *Sub) messageBody(r *http.Request, maxSize int64) (*verifyingReader, error) {
+	secrets := s.secrets()
+	if len(secrets) == 0 {
+		if !s.AllowUnsigned {
+			return nil, ErrNoSecret
+		}
+
+		return newUnsignedReader(r.Body, maxSize), nil
+	}
+
+	// check hmac
+	alg, decoded, err := parseSignature(r.Header.Get("X-Hub-Signature"),
+		s.MinSignatureAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	// this only checks the authenticity of the body. The headers could still
+	// be tampered with. After rotating, the previous secret is accepted too.
+	return newVerifyingReader(r.Body, maxSize, alg, decoded, secrets), nil
+}
+
+// Returns the largest accepted message in bytes, negative for no limit.
+func (s *Sub) maxBodySize() int64 {
+	if s.MaxBodySize == 0 {
+		return defaultMaxBodySize
+	}
+
+	return s.MaxBodySize
+}
+
+// A reader of a message body which computes its mac while it is read. At the
+// end of the body, it returns io.EOF if the mac matches any of the keys and
+// ErrBadSignature otherwise. Bodies longer than limit fail with
+// ErrBodyTooLarge.
+type verifyingReader struct {
+	r io.Reader
+
+	// Set when the body isn't signed, only the limit is checked.
+	unsigned bool
+
+	// The bytes left before the limit, negative for no limit.
+	remaining int64
+
+	macs     []hash.Hash
+	expected []byte
+
+	// the error returned by every read after the first failure or the end of
+	// the body
+	err error
+}
+
+func newVerifyingReader(r io.Reader, limit int64, alg SignatureAlgorithm,
+	expected []byte, keys [][]byte) *verifyingReader {
+	macs := make([]hash.Hash, len(keys))
+	for i, key := range keys {
+		macs[i] = hmac.New(signatureAlgorithms[alg].hash, key)
+	}
+
+	return &verifyingReader{
+		r:         r,
+		remaining: limit,
+		macs:      macs,
+		expected:  expected,
+	}
+}
+
+// Returns a reader which only checks that r isn't longer than limit.
+func newUnsignedReader(r io.Reader, limit int64) *verifyingReader {
+	return &verifyingReader{
+		r:         r,
+		remaining: limit,
+		unsigned:  true,
+	}
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	if v.err != nil {
+		return 0, v.err
+	}
+
+	// read one byte past the limit to notice bodies which are too large
+	if v.remaining >= 0 && int64(len(p)) > v.remaining+1 {
+		p = p[:v.remaining+1]
+	}
+
+	n, err := v.r.Read(p)
+	if v.remaining >= 0 {
+		if int64(n) > v.remaining {
+			v.err = ErrBodyTooLarge
+			return 0, v.err
+		}
+
+		v.remaining -= int64(n)
+	}
+
+	for _, mac := range v.macs {
+		mac.Write(p[:n])
+	}
+
+	if err == io.EOF {
+		err = v.verify()
+	}
+
+	v.err = err
+	return n, err
+}
+
+// Returns io.EOF when one of the macs matches and ErrBadSignature otherwise.
+func (v *verifyingReader) verify() error {
+	if v.unsigned {
+		return io.EOF
+	}
+
+	for _, mac := range v.macs {
+		if hmac.Equal(v.expected, mac.Sum(nil)) {
+			return io.EOF
+		}
+	}
+
+	return ErrBadSignature
+}
@@ -0,0 +1,137 @@
+package sub
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMaxBodySize(t *testing.T) {
+	called := false
+	var gotErr error
+	s := &Sub{
+		Secret:      []byte("secret"),
+		MaxBodySize: 8,
+		OnMessage: func(r *http.Request, body []byte) {
+			called = true
+		},
+		OnError: func(err error) { gotErr = err },
+	}
+
+	body := "a message longer than the limit"
+	signature := SHA1.Sign(s.Secret, []byte(body))
+
+	// known length
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	r.Header.Set("X-Hub-Signature", signature)
+
+	rw := httptest.NewRecorder()
+	s.ServeHTTP(rw, r)
+	if rw.Code != http.StatusRequestEntityTooLarge || !errors.Is(gotErr, ErrBodyTooLarge) {
+		t.Error("Expected the message to be rejected", rw.Code, gotErr)
+	}
+
+	// unknown length
+	gotErr = nil
+	r = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	r.Header.Set("X-Hub-Signature", signature)
+	r.ContentLength = -1
+
+	rw = httptest.NewRecorder()
+	s.ServeHTTP(rw, r)
+	if rw.Code != http.StatusRequestEntityTooLarge || !errors.Is(gotErr, ErrBodyTooLarge) {
+		t.Error("Expected the message to be rejected", rw.Code, gotErr)
+	}
+
+	if called {
+		t.Error("OnMessage shouldn't be called for messages which are too large")
+	}
+}
+
+func TestMessageStream(t *testing.T) {
+	body := strings.Repeat("a large message ", 1000)
+
+	tests := []struct {
+		name      string
+		signature string
+
+		// bytes read by the callback, everything when negative
+		read int
+
+		handlerErr error
+		readErr    error
+		code       int
+		err        error
+	}{
+		{
+			name:      "authentic",
+			signature: SHA256.Sign([]byte("secret"), []byte(body)),
+			read:      -1,
+			code:      http.StatusOK,
+		},
+		{
+			name:      "forged",
+			signature: SHA256.Sign([]byte("forged"), []byte(body)),
+			read:      -1,
+			readErr:   ErrBadSignature,
+			code:      http.StatusOK,
+			err:       ErrBadSignature,
+		},
+		{
+			name:      "forged and partially read",
+			signature: SHA256.Sign([]byte("forged"), []byte(body)),
+			read:      10,
+			code:      http.StatusOK,
+			err:       ErrBadSignature,
+		},
+		{
+			name:       "handler failed",
+			signature:  SHA256.Sign([]byte("secret"), []byte(body)),
+			read:       10,
+			handlerErr: io.ErrUnexpectedEOF,
+			code:       http.StatusInternalServerError,
+			err:        io.ErrUnexpectedEOF,
+		},
+	}
+
+	for _, test := range tests {
+		var gotErr error
+		s := &Sub{
+			Secret: []byte("secret"),
+			OnMessageStream: func(r *http.Request, body io.Reader) error {
+				var err error
+				if test.read < 0 {
+					_, err = ioutil.ReadAll(body)
+				} else {
+					_, err = io.ReadFull(body, make([]byte, test.read))
+				}
+
+				if err != test.readErr {
+					t.Errorf("%s: expected the read to fail with %v, got %v",
+						test.name, test.readErr, err)
+				}
+
+				return test.handlerErr
+			},
+			OnError: func(err error) { gotErr = err },
+		}
+
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		r.Header.Set("X-Hub-Signature", test.signature)
+
+		rw := httptest.NewRecorder()
+		s.ServeHTTP(rw, r)
+
+		if rw.Code != test.code {
+			t.Errorf("%s: expected %d, got %d", test.name, test.code, rw.Code)
+		}
+
+		if !errors.Is(gotErr, test.err) || (test.err == nil && gotErr != nil) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.err, gotErr)
+		}
+	}
+}
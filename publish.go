@@ -0,0 +1,161 @@
+package sub
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// The form a hub expects publish notifications in.
+type PublishStyle int
+
+const (
+	// Sends hub.mode=publish with every topic in a hub.url parameter. Most
+	// hubs expect this.
+	PublishURL PublishStyle = iota
+
+	// Sends hub.mode=publish with a single topic in hub.topic, one request
+	// per topic.
+	PublishTopic
+)
+
+// Notifies hubs that the content of topics changed, so the hubs fetch and
+// distribute it.
+type Publisher struct {
+	// The hubs which are notified.
+	Hubs []*url.URL
+
+	// The client which is used to make requests to the hubs.
+	Client *http.Client
+
+	// The form the hubs expect notifications in.
+	Style PublishStyle
+
+	// How many times a transient failure, like a network error or a 5xx
+	// response, is retried.
+	Retries int
+
+	// The delays between retries. DefaultBackoff is used when nil.
+	Backoff *Backoff
+}
+
+func NewPublisher(hubs ...*url.URL) *Publisher {
+	return &Publisher{
+		Hubs:    hubs,
+		Client:  http.DefaultClient,
+		Retries: 3,
+	}
+}
+
+// The outcome of notifying a hub about some topics.
+type PublishResult struct {
+	Hub    *url.URL
+	Topics []*url.URL
+
+	// Why the hub wasn't notified. nil on success.
+	Err error
+}
+
+// Notifies every hub that topics changed. A result is returned for every
+// request sent, so with PublishTopic there is a result for each hub and
+// topic. Hubs are notified one after another.
+func (p *Publisher) Publish(ctx context.Context, topics ...*url.URL) []PublishResult {
+	batches := [][]*url.URL{topics}
+	if p.Style == PublishTopic {
+		batches = nil
+		for _, topic := range topics {
+			batches = append(batches, []*url.URL{topic})
+		}
+	}
+
+	results := []PublishResult{}
+	for _, hub := range p.Hubs {
+		for _, batch := range batches {
+			results = append(results, PublishResult{
+				Hub:    hub,
+				Topics: batch,
+				Err:    p.publishWithRetries(ctx, hub, batch),
+			})
+		}
+	}
+
+	return results
+}
+
+// Sends a notification, retrying transient failures.
+func (p *Publisher) publishWithRetries(ctx context.Context, hub *url.URL, topics []*url.URL) error {
+	backoff := p.Backoff
+	if backoff == nil {
+		backoff = &DefaultBackoff
+	}
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = p.publish(ctx, hub, topics)
+		if err == nil || attempt >= p.Retries || !isTransient(err) {
+			return err
+		}
+
+		timer := time.NewTimer(backoff.delay(attempt))
+		select {
+		case <-timer.C:
+
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
+
+// Sends a single notification to hub.
+func (p *Publisher) publish(ctx context.Context, hub *url.URL, topics []*url.URL) error {
+	key := "hub.url"
+	if p.Style == PublishTopic {
+		key = "hub.topic"
+	}
+
+	values := url.Values{}
+	values.Set("hub.mode", publishMode)
+	for _, topic := range topics {
+		values.Add(key, topic.String())
+	}
+
+	req, err := http.NewRequest(http.MethodPost, hub.String(),
+		strings.NewReader(values.Encode()))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &ResponseError{
+			Response: resp,
+			Message:  "Publish rejected",
+		}
+	}
+
+	return nil
+}
+
+// Returns true for failures which may not happen again, like network errors
+// and responses with 5xx or 429 status codes.
+func isTransient(err error) bool {
+	respErr, ok := err.(*ResponseError)
+	if !ok {
+		// network error
+		return true
+	}
+
+	code := respErr.Response.StatusCode
+	return code >= 500 || code == http.StatusTooManyRequests
+}
@@ -0,0 +1,131 @@
+package sub
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPublishStyles(t *testing.T) {
+	var forms []url.Values
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		forms = append(forms, r.PostForm)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	a := MustParseUrl("https://example.com/a")
+	b := MustParseUrl("https://example.com/b")
+
+	tests := []struct {
+		style    PublishStyle
+		expected []url.Values
+	}{
+		{
+			style: PublishURL,
+			expected: []url.Values{
+				{"hub.mode": {"publish"}, "hub.url": {a.String(), b.String()}},
+			},
+		},
+		{
+			style: PublishTopic,
+			expected: []url.Values{
+				{"hub.mode": {"publish"}, "hub.topic": {a.String()}},
+				{"hub.mode": {"publish"}, "hub.topic": {b.String()}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		forms = nil
+
+		p := NewPublisher(MustParseUrl(ts.URL))
+		p.Style = test.style
+
+		results := p.Publish(context.Background(), a, b)
+		if len(results) != len(test.expected) {
+			t.Fatal("Unexpected results", results)
+		}
+
+		for _, result := range results {
+			if result.Err != nil {
+				t.Error(result.Err)
+			}
+		}
+
+		if !reflect.DeepEqual(forms, test.expected) {
+			t.Error("Got", forms, "expected", test.expected)
+		}
+	}
+}
+
+func TestPublishRetries(t *testing.T) {
+	tests := []struct {
+		statuses []int
+		attempts int
+		success  bool
+	}{
+		{[]int{503, 502, 204}, 3, true},
+		{[]int{429, 429, 429, 429, 429}, 3, false},
+		{[]int{400, 204}, 1, false},
+	}
+
+	for _, test := range tests {
+		attempts := 0
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(test.statuses[attempts])
+			attempts++
+		})
+
+		ts := httptest.NewServer(handler)
+
+		p := NewPublisher(MustParseUrl(ts.URL))
+		p.Retries = 2
+		p.Backoff = &Backoff{Initial: time.Millisecond, Multiplier: 1}
+
+		results := p.Publish(context.Background(), MustParseUrl("https://example.com/"))
+		if (results[0].Err == nil) != test.success {
+			t.Error(test.statuses, "unexpected result", results[0].Err)
+		}
+
+		if attempts != test.attempts {
+			t.Error(test.statuses, "expected", test.attempts, "attempts, got", attempts)
+		}
+
+		ts.Close()
+	}
+}
+
+func TestPublishResultsPerHub(t *testing.T) {
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer good.Close()
+
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer bad.Close()
+
+	p := NewPublisher(MustParseUrl(good.URL), MustParseUrl(bad.URL))
+	results := p.Publish(context.Background(), MustParseUrl("https://example.com/"))
+
+	if len(results) != 2 {
+		t.Fatal("Expected a result per hub", results)
+	}
+
+	if results[0].Hub.String() != good.URL || results[0].Err != nil {
+		t.Error("Unexpected result", results[0])
+	}
+
+	if results[1].Hub.String() != bad.URL || results[1].Err == nil {
+		t.Error("Unexpected result", results[1])
+	}
+}
@@ -18,14 +18,20 @@ type Config struct {
 	// The publicly accessible path of this callback server. This is used by
 	// hubs to send notifications.
 	BasePath *URL
+
+	// The path of a file where subscriptions are saved. When set, subscriptions
+	// are restored from this file at startup instead of subscribing again and
+	// are kept active with the hub on shutdown.
+	StatePath string
 }
 
 type Subscription struct {
 	// The topic url of the feed we are subscribing to. This field is required.
 	Topic *URL
 
-	// The hub providing updates about the topic. This can be discovered by
-	// looking up the topic url.
+	// The hub providing updates about the topic. When missing, it is
+	// discovered by looking up the topic url and every advertised hub is used,
+	// falling back to the next hub when subscribing fails.
 	Hub *URL
 
 	// An array of a command followed by arguments called when a verified
@@ -36,8 +42,39 @@ type Subscription struct {
 	// there is no way to verify state change requests to the callback server
 	// originate from the hub.
 	AllowInsecure bool
+
+	// Don't send a secret to the hub and accept unsigned messages, for hubs
+	// which don't support secrets. This is insecure because anyone who knows
+	// the callback url can send messages.
+	AllowUnsigned bool
+
+	// Only run the command for messages with new content. Messages are
+	// skipped when every entry of the feed was seen before or, for other
+	// messages, when the same body was seen before. Hubs retry deliveries and
+	// some send the whole feed on every update.
+	Dedup bool
+
+	// How many entries and messages are remembered for Dedup. Defaults to
+	// 1000. The remembered entries are saved in statepath when set.
+	DedupWindow int
+
+	// Fetch the topic when the hub sends a message without content and run
+	// the command with the topic instead. Some hubs only notify subscribers
+	// of changes without sending the new content.
+	FetchThinPings bool
+
+	// What the command is run with. With "message", the default, it runs
+	// once per message with the message. With "new_entries", it runs once for
+	// every entry of a feed which was added or changed since it was last seen,
+	// with the entry. Other messages are passed as with "message".
+	Deliver string
 }
 
+const (
+	deliverMessage    = "message"
+	deliverNewEntries = "new_entries"
+)
+
 // Parses and validates the toml configuration.
 func GetConfigReader(r io.Reader) (*Config, error) {
 	// decode configuration
@@ -72,6 +109,16 @@ func GetConfigReader(r io.Reader) (*Config, error) {
 		if len(sub.Command) < 1 {
 			return nil, &FieldMissingError{"subscriptions." + name + ".command"}
 		}
+
+		switch sub.Deliver {
+		case "", deliverMessage, deliverNewEntries:
+
+		default:
+			return nil, &FieldValueError{
+				Field: "subscriptions." + name + ".deliver",
+				Value: sub.Deliver,
+			}
+		}
 	}
 
 	return config, nil
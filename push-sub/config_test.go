@@ -13,12 +13,38 @@ basepath="https://sub.shipped.com/"
 topic="https://example.com/feed.xml"
 hub="https://example.com/hub"
 command=["/usr/bin/tee", "/tmp/output.txt"]
+dedup=true
+dedupwindow=100
 `
 
+func TestParseConfigDeliver(t *testing.T) {
+	r := strings.NewReader(config + `deliver="new_entries"`)
+	conf, err := GetConfigReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if conf.Subscriptions["blog_feed"].Deliver != deliverNewEntries {
+		t.Error("Unexpected deliver", conf.Subscriptions["blog_feed"].Deliver)
+	}
+
+	r = strings.NewReader(config + `deliver="everything"`)
+	_, err = GetConfigReader(r)
+	if _, ok := err.(*FieldValueError); !ok {
+		t.Error("Expected a FieldValueError, got", err)
+	}
+}
+
 func TestParseConfig(t *testing.T) {
 	r := strings.NewReader(config)
-	_, err := GetConfigReader(r)
+	conf, err := GetConfigReader(r)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
+	}
+
+	subscription := conf.Subscriptions["blog_feed"]
+	if !subscription.Dedup || subscription.DedupWindow != 100 {
+		t.Error("Unexpected dedup settings", subscription.Dedup,
+			subscription.DedupWindow)
 	}
 }
@@ -22,6 +22,17 @@ func (err *UndecodedError) Error() string {
 	return message
 }
 
+// Error returned by GetConfigReader when a field has a value which isn't
+// allowed.
+type FieldValueError struct {
+	Field string
+	Value string
+}
+
+func (e *FieldValueError) Error() string {
+	return fmt.Sprintf("%s has invalid value %q", e.Field, e.Value)
+}
+
 // Error returned by GetConfigReader when a field is missing or empty.
 type FieldMissingError struct {
 	Field string
@@ -2,13 +2,13 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"flag"
 	"net"
 	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
-	"sync"
 	"time"
 
 	"github.com/0xcaff/sub"
@@ -27,16 +27,31 @@ var (
 		4, // InfoLevel
 		"The log level. Higher is more detailed.",
 	)
+
+	shutdownTimeout = flag.Duration(
+		"shutdown-timeout",
+		10*time.Second,
+		"How long to wait for hubs to accept unsubscriptions when shutting down.",
+	)
 )
 
+// The source of time for every subscription.
+var clock = sub.SystemClock
+
+// Subscriptions with leases expiring sooner than this are renewed at startup
+// instead of being restored.
+const restoreMargin = 10 * time.Minute
+
 func main() {
 	flag.Parse()
 
-	subscriptions := map[string]*sub.Sub{}
-
 	// set log level
 	log.SetLevel(log.Level(*verbosity))
 
+	if flag.Arg(0) == "publish" {
+		os.Exit(publish(flag.Args()[1:]))
+	}
+
 	log.Info("reading config: ", *confPath)
 	// open config file
 	conf, err := GetConfig(*confPath)
@@ -45,46 +60,42 @@ func main() {
 		return
 	}
 
-	// The muxer which will be delegating all requests to the callback server.
-	mux := &http.ServeMux{}
+	// open the store of subscriptions from earlier runs
+	var store *sub.FileStore
+	if len(conf.StatePath) > 0 {
+		log.Info("opening state: ", conf.StatePath)
+		store, err = sub.OpenFileStore(conf.StatePath)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+	}
+
+	// The manager which will be routing all requests to the callback server.
+	manager := sub.NewManager(&conf.BasePath.URL)
+	if store != nil {
+		manager.Store = store
+	}
 
 	// register all listeners
 	for name, subscription := range conf.Subscriptions {
+		name, subscription := name, subscription
 		fields := log.Fields{"name": name}
 
 		s := sub.New()
+		s.Clock = clock
 		s.Topic = &subscription.Topic.URL
-
-		// discover hub if needed
-		if subscription.Hub == nil {
-			log.WithFields(fields).Info("discovering hub")
-
-			err = s.Discover()
-			if err != nil {
-				log.WithFields(fields).Error(err)
-				return
-			}
-
-			// ensure secure hub
-			if !subscription.AllowInsecure {
-				s.Hub.Scheme = "https"
-			}
-
-			log.WithFields(fields).Info("discovered hub: ", s.Hub)
-		} else {
+		if subscription.Hub != nil {
 			s.Hub = &subscription.Hub.URL
 		}
 
-		// register at random endpoint. We are using a random, hard to guess
-		// endpoint for security.
-		endpoint := "/" + string(sub.RandAlphanumBytes(99))
+		// messages signed with the old secret are accepted for a while
+		s.RotateSecretOnRenew = true
+		s.AllowUnsigned = subscription.AllowUnsigned
+		s.FetchThinPings = subscription.FetchThinPings
 
-		// record callback url
-		s.Callback = conf.BasePath.ResolveReference(
-			MustParseUrl(endpoint))
-
-		if log.GetLevel() >= log.InfoLevel {
-			fields["endpoint"] = s.Callback.String()
+		if subscription.Dedup {
+			s.Dedup = sub.NewDeduplicator(subscription.DedupWindow)
 		}
 
 		// setup listeners
@@ -93,26 +104,27 @@ func main() {
 			log.WithFields(fields).Info("received message")
 			log.WithFields(fields).Debug("message: ", string(body))
 
-			// create stdin
-			stdin := bytes.NewReader(body)
+			runCommand(name, subscription.Command, body)
+		}
 
-			// create cmd
-			cmd := exec.Command(subscription.Command[0], subscription.Command[1:]...)
-			cmd.Stdin = stdin
+		if subscription.Deliver == deliverNewEntries {
+			// remember the entries to only pass on the new and changed ones,
+			// other messages are only deduplicated with dedup
+			if s.Dedup == nil {
+				s.Dedup = sub.NewDeduplicator(subscription.DedupWindow)
+				s.Dedup.EntriesOnly = true
+			}
+			s.Dedup.IncludeChanged = true
 
-			// launch cmd
-			go func() {
-				log.WithFields(log.Fields{
-					"name": name,
-					"args": subscription.Command,
-				}).Info("running command")
+			s.OnEntries = func(_ *http.Request, entries []sub.Entry) {
+				fields := log.Fields{"name": name}
+				log.WithFields(fields).Info("received ", len(entries), " entries")
 
-				output, err := cmd.CombinedOutput()
-				log.Debug("Output: ", string(output))
-				if err != nil {
-					log.WithFields(fields).Error(err)
+				for _, entry := range entries {
+					log.WithFields(fields).Debug("entry: ", entry.ID)
+					runCommand(name, subscription.Command, entry.Raw)
 				}
-			}()
+			}
 		}
 
 		s.OnError = func(err error) {
@@ -120,28 +132,57 @@ func main() {
 			log.WithFields(fields).Warning(err)
 		}
 
-		s.OnRenewLease = func(s *sub.Sub) {
-			log.WithFields(fields).Info("renewing")
+		// renewals are retried automatically until the lease lapses
+		s.OnLeaseExpired = func(s *sub.Sub, err error) {
+			log.WithFields(fields).Error("lease expired: ", err)
+		}
 
-			// TODO: Shutdown when all renewals have failed.
+		// register the callback, restoring the subscription from an earlier
+		// run if possible
+		err = manager.Add(name, s)
+		if err != nil {
+			log.WithFields(fields).Error(err)
+			return
+		}
 
-			// only try to renew once
-			err := s.Subscribe()
+		if !s.NeedsRenewal(restoreMargin) {
+			log.WithFields(fields).Info("restored subscription to: ", s.Hub)
+		} else if s.Hub == nil {
+			// discover hub if needed
+			log.WithFields(fields).Info("discovering hub")
+
+			err = s.Discover()
 			if err != nil {
-				log.Error(err)
+				log.WithFields(fields).Error(err)
+				return
 			}
+
+			if s.Hub == nil {
+				log.WithFields(fields).Error("no hub advertised")
+				return
+			}
+
+			// ensure secure hubs
+			if !subscription.AllowInsecure {
+				for _, hub := range s.Hubs {
+					hub.Scheme = "https"
+				}
+			}
+
+			// additional hubs are used when subscribing to the first fails
+			log.WithFields(fields).Info("discovered hubs: ", s.Hubs)
 		}
 
-		// register the callback
-		log.WithFields(fields).Info("registered")
-		mux.Handle(endpoint, s)
+		if log.GetLevel() >= log.InfoLevel {
+			fields["endpoint"] = s.Callback.String()
+		}
 
-		subscriptions[name] = s
+		log.WithFields(fields).Info("registered")
 	}
 
 	server := &http.Server{
 		Addr:    conf.Address,
-		Handler: mux,
+		Handler: manager,
 	}
 
 	// start listening
@@ -162,36 +203,49 @@ func main() {
 		isShuttingDown = true
 
 		log.Info("shutting down")
-		var wg sync.WaitGroup
-		for name, subscription := range subscriptions {
-			wg.Add(1)
-			go func() {
-				fields := log.Fields{"name": name}
-				log.WithFields(fields).Info("unsubscribing")
 
-				err := subscription.Unsubscribe()
-				if err != nil {
-					log.WithFields(fields).Error(err)
-				}
+		// don't let a hung hub stall the shutdown
+		ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+		defer cancel()
+
+		if store != nil {
+			// the subscriptions are picked up again on the next run
+			log.Info("keeping subscriptions in: ", conf.StatePath)
+		} else {
+			log.Info("unsubscribing")
+			for _, name := range manager.List() {
+				s, _ := manager.Get(name)
+				s.UnsubscribeOnClose = true
+			}
+		}
 
-				log.WithFields(fields).Info("unsubscribed")
-				wg.Done()
-			}()
+		// stop renewals and wait for commands being started
+		err := manager.Close(ctx)
+		if err != nil {
+			log.Error(err)
+		} else if store == nil {
+			log.Info("unsubscribed")
 		}
 
-		wg.Wait()
 		os.Exit(0)
 	}()
 
 	go func() {
 		// try subscribing to all subscriptions
-		for name, subscription := range subscriptions {
+		for _, name := range manager.List() {
 			if isShuttingDown {
 				break
 			}
 
+			subscription, _ := manager.Get(name)
+
 			fields := log.Fields{"name": name}
 
+			if !subscription.NeedsRenewal(restoreMargin) {
+				// restored with plenty of lease left
+				continue
+			}
+
 			err := subscription.Subscribe()
 			log.WithFields(fields).Info("subscribing")
 
@@ -215,3 +269,26 @@ func main() {
 		return
 	}
 }
+
+// Runs command in the background with stdin as its standard input.
+func runCommand(name string, command []string, stdin []byte) {
+	fields := log.Fields{"name": name}
+
+	// create cmd
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin = bytes.NewReader(stdin)
+
+	// launch cmd
+	go func() {
+		log.WithFields(log.Fields{
+			"name": name,
+			"args": command,
+		}).Info("running command")
+
+		output, err := cmd.CombinedOutput()
+		log.Debug("Output: ", string(output))
+		if err != nil {
+			log.WithFields(fields).Error(err)
+		}
+	}()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net/url"
+
+	"github.com/0xcaff/sub"
+	log "github.com/sirupsen/logrus"
+)
+
+// Runs the publish command which notifies hubs that topics changed. The hubs
+// are discovered from the first topic unless given with -hub. Returns the exit
+// code.
+func publish(args []string) int {
+	flags := flag.NewFlagSet("publish", flag.ExitOnError)
+	hubs := urlList{}
+	flags.Var(&hubs, "hub", "A hub to notify. Can be repeated. Discovered from the topic by default.")
+	style := flags.String("style", "url", `How topics are sent to hubs, either "url" (hub.url) or "topic" (hub.topic).`)
+	flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		log.Error("usage: push-sub publish [-hub url] [-style url|topic] topic...")
+		return 2
+	}
+
+	topics := []*url.URL{}
+	for _, rawTopic := range flags.Args() {
+		topic, err := url.Parse(rawTopic)
+		if err != nil {
+			log.Error(err)
+			return 2
+		}
+
+		topics = append(topics, topic)
+	}
+
+	publisher := sub.NewPublisher(hubs...)
+	switch *style {
+	case "url":
+		publisher.Style = sub.PublishURL
+	case "topic":
+		publisher.Style = sub.PublishTopic
+	default:
+		log.Error("unknown style: ", *style)
+		return 2
+	}
+
+	if len(publisher.Hubs) == 0 {
+		log.Info("discovering hubs")
+		discovery, err := sub.DiscoverTopic(publisher.Client, topics[0])
+		if err != nil {
+			log.Error(err)
+			return 1
+		}
+
+		publisher.Hubs = discovery.Hubs
+		if len(publisher.Hubs) == 0 {
+			log.Error("no hub advertised")
+			return 1
+		}
+	}
+
+	code := 0
+	for _, result := range publisher.Publish(context.Background(), topics...) {
+		fields := log.Fields{"hub": result.Hub.String()}
+		if result.Err != nil {
+			log.WithFields(fields).Error(result.Err)
+			code = 1
+			continue
+		}
+
+		log.WithFields(fields).Info("published")
+	}
+
+	return code
+}
+
+// A flag.Value collecting repeated url flags.
+type urlList []*url.URL
+
+func (l *urlList) String() string {
+	return ""
+}
+
+func (l *urlList) Set(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+
+	*l = append(*l, u)
+	return nil
+}
@@ -0,0 +1,283 @@
+package sub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Sent to OnError when a lease expired because every renewal failed. It wraps
+// the error of the last renewal attempt.
+var ErrLeaseExpired = errors.New("lease expired")
+
+// Sent to OnError when the hub accepted a renewal but didn't verify it before
+// Sub.PendingTimeout. The renewal is retried.
+var ErrNotVerified = errors.New("renewal not verified")
+
+const (
+	// The fraction of the lease after which it is renewed when
+	// Sub.RenewalFraction is zero.
+	defaultRenewalFraction = 0.75
+
+	// The lease assumed when the hub omits it and Sub.DefaultLease is zero.
+	defaultLease = 24 * time.Hour
+)
+
+// Returns the lease granted by a verification from the raw hub.lease_seconds.
+// Missing and zero leases are replaced with the default lease and the result
+// is clamped to MaxLease.
+func (s *Sub) parseLease(raw string) (time.Duration, error) {
+	var lease time.Duration
+	if raw != "" {
+		seconds, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %v", ErrBadLease, err)
+		}
+
+		if seconds < 0 {
+			return 0, fmt.Errorf("%w: negative lease %d", ErrBadLease, seconds)
+		}
+
+		lease = time.Duration(seconds) * time.Second
+	}
+
+	if lease == 0 {
+		lease = s.DefaultLease
+		if lease == 0 {
+			lease = defaultLease
+		}
+	}
+
+	if s.MaxLease > 0 && lease > s.MaxLease {
+		lease = s.MaxLease
+	}
+
+	return lease, nil
+}
+
+// Returns how long after now the lease should be renewed. With a
+// RenewalMargin, it is renewed that long before it expires. Otherwise, it is
+// renewed after RenewalFraction of the remaining lease.
+// Returns the lease in seconds to ask the hub for when leaseSeconds were
+// requested. Shorter leases than MinLease aren't asked for.
+func (s *Sub) requestedLease(leaseSeconds int) int {
+	minSeconds := int(s.MinLease / time.Second)
+	if leaseSeconds < minSeconds {
+		return minSeconds
+	}
+
+	return leaseSeconds
+}
+
+func (s *Sub) renewalDelay(now time.Time) time.Duration {
+	remaining := s.LeaseExpiry().Sub(now)
+
+	var delay time.Duration
+	if s.RenewalMargin > 0 {
+		delay = remaining - s.RenewalMargin
+	} else {
+		fraction := s.RenewalFraction
+		if fraction <= 0 || fraction > 1 {
+			fraction = defaultRenewalFraction
+		}
+
+		delay = time.Duration(float64(remaining) * fraction)
+	}
+
+	if delay < 0 {
+		delay = 0
+	}
+
+	return delay
+}
+
+// Controls how a subscription renews its lease when Sub.OnRenewLease is nil.
+// Failed renewals are retried with backoff until the deadline before the lease
+// expires.
+type RenewalPolicy struct {
+	// The delays between retries.
+	Backoff Backoff
+
+	// Retries stop when the next attempt would be later than this long before
+	// the lease expires. The default is used when zero.
+	Deadline time.Duration
+
+	// How long an attempt waits for the hub before it fails. The default is
+	// used when zero.
+	Timeout time.Duration
+}
+
+// The policy used when Sub.RenewalPolicy is nil.
+var DefaultRenewalPolicy = RenewalPolicy{
+	Backoff:  DefaultBackoff,
+	Deadline: 30 * time.Second,
+	Timeout:  30 * time.Second,
+}
+
+// Returns how long before the lease expires retries stop.
+func (p *RenewalPolicy) deadline() time.Duration {
+	if p.Deadline <= 0 {
+		return DefaultRenewalPolicy.Deadline
+	}
+
+	return p.Deadline
+}
+
+// Returns how long an attempt waits for the hub.
+func (p *RenewalPolicy) timeout() time.Duration {
+	if p.Timeout <= 0 {
+		return DefaultRenewalPolicy.Timeout
+	}
+
+	return p.Timeout
+}
+
+func (s *Sub) renewalPolicy() *RenewalPolicy {
+	if s.RenewalPolicy != nil {
+		return s.RenewalPolicy
+	}
+
+	return &DefaultRenewalPolicy
+}
+
+// Renews the lease by subscribing again. Failures, and renewals the hub
+// accepted but didn't verify in time, are retried according to the renewal
+// policy. If the lease can't be renewed before the deadline, the expiry is
+// reported once the lease lapses. Retries and the expiry are scheduled on the
+// clock rather than waited for, and stop when cancel is closed. Closing cancel
+// also aborts the request to the hub in progress.
+func (s *Sub) renew(cancel chan struct{}) {
+	s.renewAttempt(cancel, 0)
+}
+
+// Makes renewal attempt number attempt, starting at 0.
+func (s *Sub) renewAttempt(cancel chan struct{}, attempt int) {
+	ctx, cancelAttempt := s.renewalContext(cancel)
+	err := s.SubscribeWithLeaseContext(ctx, 0)
+	cancelAttempt()
+
+	if err != nil {
+		select {
+		case <-cancel:
+			// cancelled, or replaced by the verification of this attempt
+			return
+
+		default:
+		}
+
+		s.renewFailed(cancel, attempt, err)
+		return
+	}
+
+	// The verification schedules the next renewal, which cancels this one.
+	// Until it arrives, keep a timer running to retry once the request timed
+	// out.
+	clock := s.clock()
+	retryDeadline := s.LeaseExpiry().Add(-s.renewalPolicy().deadline())
+
+	wait := s.pendingTimeout()
+	if remaining := retryDeadline.Sub(clock.Now()); remaining < wait {
+		wait = remaining
+	}
+
+	s.scheduleRenewalStep(cancel, wait, func() {
+		s.renewFailed(cancel, attempt, ErrNotVerified)
+	})
+}
+
+// Returns the context of a renewal attempt. It is done once cancel is closed
+// or the attempt timed out.
+func (s *Sub) renewalContext(cancel chan struct{}) (context.Context, context.CancelFunc) {
+	ctx, cancelCtx := context.WithTimeout(context.Background(), s.renewalPolicy().timeout())
+
+	go func() {
+		select {
+		case <-cancel:
+			cancelCtx()
+
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancelCtx
+}
+
+// Reports the failure of renewal attempt number attempt then schedules a retry
+// or, past the deadline, the expiry of the lease.
+func (s *Sub) renewFailed(cancel chan struct{}, attempt int, err error) {
+	if s.OnError != nil {
+		s.OnError(err)
+	}
+
+	clock := s.clock()
+	policy := s.renewalPolicy()
+	retryDeadline := s.LeaseExpiry().Add(-policy.deadline())
+
+	delay := policy.Backoff.delay(attempt)
+	if clock.Now().Add(delay).After(retryDeadline) {
+		// give up, wait for the lease to lapse
+		s.scheduleRenewalStep(cancel, s.LeaseExpiry().Sub(clock.Now()), func() {
+			s.expireLease(err)
+		})
+
+		return
+	}
+
+	s.scheduleRenewalStep(cancel, delay, func() {
+		s.renewAttempt(cancel, attempt+1)
+	})
+}
+
+// Schedules the next step of the renewal identified by cancel. Nothing is
+// scheduled if the renewal was cancelled or replaced in the meantime.
+func (s *Sub) scheduleRenewalStep(cancel chan struct{}, d time.Duration, f func()) {
+	s.renewLock.Lock()
+	defer s.renewLock.Unlock()
+
+	if s.cancelRenew != cancel {
+		return
+	}
+
+	s.renewTimer = s.clock().AfterFunc(d, func() {
+		if !s.track() {
+			// closed while the timer fired
+			return
+		}
+		defer s.untrack()
+
+		f()
+	})
+}
+
+// Reports a lease which lapsed because renewing it failed with err.
+func (s *Sub) expireLease(err error) {
+	s.setState(Unsubscribed)
+
+	if s.OnError != nil {
+		s.OnError(&renewalError{err})
+	}
+
+	if s.OnLeaseExpired != nil {
+		s.OnLeaseExpired(s, err)
+	}
+}
+
+// Describes a lease which lapsed because renewing it failed. It matches
+// ErrLeaseExpired and unwraps to the last renewal failure.
+type renewalError struct {
+	Err error
+}
+
+func (e *renewalError) Error() string {
+	return ErrLeaseExpired.Error() + ": " + e.Err.Error()
+}
+
+func (e *renewalError) Is(target error) bool {
+	return target == ErrLeaseExpired
+}
+
+func (e *renewalError) Unwrap() error {
+	return e.Err
+}
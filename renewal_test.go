@@ -0,0 +1,347 @@
+package sub
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseLease(t *testing.T) {
+	tests := []struct {
+		name     string
+		sub      *Sub
+		raw      string
+		expected time.Duration
+		err      bool
+	}{
+		{name: "granted", sub: &Sub{}, raw: "3600", expected: time.Hour},
+		{name: "omitted", sub: &Sub{}, raw: "", expected: defaultLease},
+		{name: "zero", sub: &Sub{}, raw: "0", expected: defaultLease},
+		{
+			name:     "configured default",
+			sub:      &Sub{DefaultLease: time.Hour},
+			raw:      "",
+			expected: time.Hour,
+		},
+		{
+			name:     "shorter than the minimum",
+			sub:      &Sub{MinLease: time.Hour},
+			raw:      "60",
+			expected: time.Minute,
+		},
+		{
+			name:     "clamped down",
+			sub:      &Sub{MaxLease: time.Hour},
+			raw:      "31536000",
+			expected: time.Hour,
+		},
+		{name: "malformed", sub: &Sub{}, raw: "forever", err: true},
+		{name: "negative", sub: &Sub{}, raw: "-1", err: true},
+	}
+
+	for _, test := range tests {
+		lease, err := test.sub.parseLease(test.raw)
+		if test.err {
+			if !errors.Is(err, ErrBadLease) {
+				t.Error(test.name, "expected ErrBadLease, got", err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Error(test.name, err)
+		}
+
+		if lease != test.expected {
+			t.Error(test.name, "got", lease, "expected", test.expected)
+		}
+	}
+}
+
+func TestRenewalDelay(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		sub      *Sub
+		expected time.Duration
+	}{
+		{name: "default", sub: &Sub{}, expected: 75 * time.Hour},
+		{
+			name:     "fraction",
+			sub:      &Sub{RenewalFraction: 0.5},
+			expected: 50 * time.Hour,
+		},
+		{
+			name:     "margin",
+			sub:      &Sub{RenewalMargin: 10 * time.Hour},
+			expected: 90 * time.Hour,
+		},
+		{
+			name:     "margin longer than lease",
+			sub:      &Sub{RenewalMargin: 200 * time.Hour},
+			expected: 0,
+		},
+	}
+
+	for _, test := range tests {
+		test.sub.leaseExpiry = now.Add(100 * time.Hour)
+
+		delay := test.sub.renewalDelay(now)
+		if delay != test.expected {
+			t.Error(test.name, "got", delay, "expected", test.expected)
+		}
+	}
+}
+
+func TestRenewalRetries(t *testing.T) {
+	attempts := 0
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	clock := newFakeClock()
+	errs := 0
+	sub := &Sub{
+		Hub:         MustParseUrl(ts.URL),
+		Topic:       MustParseUrl("https://example.com/feed.xml"),
+		Callback:    MustParseUrl("https://my-server.com/subscriber"),
+		Client:      http.DefaultClient,
+		Clock:       clock,
+		state:       Subscribed,
+		leaseExpiry: clock.Now().Add(time.Hour),
+		OnError:     func(err error) { errs++ },
+		RenewalPolicy: &RenewalPolicy{
+			Backoff: Backoff{Initial: time.Second, Multiplier: 2},
+		},
+	}
+
+	sub.scheduleRenewal()
+
+	// the renewal is due at 45 minutes, the retries 1s and 2s after
+	clock.Advance(45*time.Minute + 2*time.Second)
+	if attempts != 2 || errs != 2 {
+		t.Error("Expected 2 attempts and 2 errors, got", attempts, errs)
+	}
+
+	clock.Advance(time.Second)
+	if attempts != 3 || errs != 2 {
+		t.Error("Expected 3 attempts and 2 errors, got", attempts, errs)
+	}
+}
+
+func TestRenewalLeaseExpires(t *testing.T) {
+	attempts := 0
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	clock := newFakeClock()
+	expiry := clock.Now().Add(time.Hour)
+
+	var lastErr error
+	var expiredAt time.Time
+	sub := &Sub{
+		Hub:         MustParseUrl(ts.URL),
+		Topic:       MustParseUrl("https://example.com/feed.xml"),
+		Callback:    MustParseUrl("https://my-server.com/subscriber"),
+		Client:      http.DefaultClient,
+		Clock:       clock,
+		state:       Subscribed,
+		leaseExpiry: expiry,
+		OnError:     func(err error) { lastErr = err },
+		OnLeaseExpired: func(s *Sub, err error) {
+			expiredAt = clock.Now()
+
+			if _, ok := err.(*ResponseError); !ok {
+				t.Error("Expected the last renewal error, got", err)
+			}
+		},
+		RenewalPolicy: &RenewalPolicy{
+			Backoff:  Backoff{Initial: time.Minute, Multiplier: 1},
+			Deadline: 5 * time.Minute,
+		},
+	}
+
+	sub.scheduleRenewal()
+	clock.Advance(2 * time.Hour)
+
+	// one attempt at 45 minutes then a retry every minute until 55 minutes
+	if attempts != 11 {
+		t.Error("Expected 11 attempts, got", attempts)
+	}
+
+	if !expiredAt.Equal(expiry) {
+		t.Error("Expected the lease to expire at", expiry, "got", expiredAt)
+	}
+
+	if !errors.Is(lastErr, ErrLeaseExpired) {
+		t.Error("Expected ErrLeaseExpired, got", lastErr)
+	}
+
+	if sub.State() != Unsubscribed {
+		t.Error("Expected the subscription to be unsubscribed, got", sub.State())
+	}
+}
+
+func TestRenewalRetriesCancelled(t *testing.T) {
+	attempts := 0
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	clock := newFakeClock()
+	sub := &Sub{
+		Hub:         MustParseUrl(ts.URL),
+		Topic:       MustParseUrl("https://example.com/feed.xml"),
+		Callback:    MustParseUrl("https://my-server.com/subscriber"),
+		Client:      http.DefaultClient,
+		Clock:       clock,
+		state:       Subscribed,
+		leaseExpiry: clock.Now().Add(time.Hour),
+		OnLeaseExpired: func(s *Sub, err error) {
+			t.Error("A cancelled renewal reported an expiry")
+		},
+		RenewalPolicy: &RenewalPolicy{
+			Backoff: Backoff{Initial: time.Minute, Multiplier: 1},
+		},
+	}
+
+	sub.scheduleRenewal()
+	clock.Advance(45 * time.Minute)
+	if attempts != 1 {
+		t.Fatal("Expected 1 attempt, got", attempts)
+	}
+
+	if !sub.CancelRenewal() {
+		t.Error("Expected the retries to be cancellable")
+	}
+
+	clock.Advance(2 * time.Hour)
+	if attempts != 1 {
+		t.Error("Expected no retries after cancelling, got", attempts)
+	}
+}
+
+func TestRenewalNotVerified(t *testing.T) {
+	attempts := 0
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// accept every renewal without ever verifying it
+		attempts++
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	clock := newFakeClock()
+	errs := []error{}
+	var expiredErr error
+	sub := &Sub{
+		Hub:            MustParseUrl(ts.URL),
+		Topic:          MustParseUrl("https://example.com/feed.xml"),
+		Callback:       MustParseUrl("https://my-server.com/subscriber"),
+		Client:         http.DefaultClient,
+		Clock:          clock,
+		state:          Subscribed,
+		leaseExpiry:    clock.Now().Add(time.Hour),
+		PendingTimeout: 5 * time.Minute,
+		OnError:        func(err error) { errs = append(errs, err) },
+		OnLeaseExpired: func(s *Sub, err error) { expiredErr = err },
+		RenewalPolicy: &RenewalPolicy{
+			Backoff:  Backoff{Initial: time.Minute, Multiplier: 1},
+			Deadline: 5 * time.Minute,
+		},
+	}
+
+	sub.scheduleRenewal()
+	clock.Advance(2 * time.Hour)
+
+	// renewals at 45 and 51 minutes, each given up on after the pending
+	// timeout or the deadline
+	if attempts != 2 {
+		t.Error("Expected 2 attempts, got", attempts)
+	}
+
+	if len(errs) != 3 || !errors.Is(errs[0], ErrNotVerified) ||
+		!errors.Is(errs[1], ErrNotVerified) || !errors.Is(errs[2], ErrLeaseExpired) {
+		t.Error("Unexpected errors", errs)
+	}
+
+	if !errors.Is(expiredErr, ErrNotVerified) {
+		t.Error("Expected the lease to expire unverified, got", expiredErr)
+	}
+
+	if sub.State() != Unsubscribed {
+		t.Error("Expected the subscription to be unsubscribed, got", sub.State())
+	}
+}
+
+func TestRenewalTimeout(t *testing.T) {
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// hang until the test is over
+		<-release
+	})
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+	defer close(release)
+
+	clock := newFakeClock()
+	var gotErr error
+	sub := &Sub{
+		Hub:         MustParseUrl(ts.URL),
+		Topic:       MustParseUrl("https://example.com/feed.xml"),
+		Callback:    MustParseUrl("https://my-server.com/subscriber"),
+		Client:      http.DefaultClient,
+		Clock:       clock,
+		state:       Subscribed,
+		leaseExpiry: clock.Now().Add(time.Hour),
+		OnError:     func(err error) { gotErr = err },
+		RenewalPolicy: &RenewalPolicy{
+			Timeout: 10 * time.Millisecond,
+		},
+	}
+	defer sub.CancelRenewal()
+
+	sub.scheduleRenewal()
+	clock.Advance(45 * time.Minute)
+
+	if !errors.Is(gotErr, context.DeadlineExceeded) {
+		t.Error("Expected the attempt to time out, got", gotErr)
+	}
+}
+
+func TestRenewalPolicyDefaults(t *testing.T) {
+	policy := &RenewalPolicy{}
+
+	if policy.deadline() != DefaultRenewalPolicy.Deadline {
+		t.Error("Expected the default deadline, got", policy.deadline())
+	}
+
+	if policy.timeout() != DefaultRenewalPolicy.Timeout {
+		t.Error("Expected the default timeout, got", policy.timeout())
+	}
+}
@@ -0,0 +1,86 @@
+package sub
+
+import "time"
+
+// The default of Sub.SecretGracePeriod.
+const defaultSecretGracePeriod = time.Hour
+
+// Replaces the secret with a new random one. The hub learns about it with the
+// next subscription request. Until the hub verifies that request and for
+// SecretGracePeriod after, messages signed with the previous secret are still
+// accepted. Subscriptions without a secret which allow unsigned messages are
+// left unsigned.
+func (s *Sub) RotateSecret() {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	if len(s.Secret) == 0 && s.AllowUnsigned {
+		return
+	}
+
+	// keep the secret the hub is known to use when rotating again before it
+	// verified the last rotation
+	if len(s.previousSecret) == 0 || !s.previousSecretExpiry.IsZero() {
+		s.previousSecret = s.Secret
+		s.previousSecretExpiry = time.Time{}
+	}
+
+	s.Secret = RandAlphanumBytes(maxSecretLen)
+}
+
+// Returns the secret to send to the hub, generating one if there is none
+// unless unsigned messages are allowed.
+func (s *Sub) secretForRequest() []byte {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	if len(s.Secret) == 0 && !s.AllowUnsigned {
+		s.Secret = RandAlphanumBytes(maxSecretLen)
+	}
+
+	return s.Secret
+}
+
+// Starts the grace period of the previous secret. Called when the hub
+// verified a subscription, after which it signs with the current secret.
+func (s *Sub) startSecretGracePeriod() {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	if len(s.previousSecret) == 0 || !s.previousSecretExpiry.IsZero() {
+		return
+	}
+
+	grace := s.SecretGracePeriod
+	if grace <= 0 {
+		grace = defaultSecretGracePeriod
+	}
+
+	s.previousSecretExpiry = s.clock().Now().Add(grace)
+}
+
+// Returns the secrets messages may be signed with, newest first. It is empty
+// when the subscription has no secret.
+func (s *Sub) secrets() [][]byte {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	secrets := [][]byte{}
+	if len(s.Secret) > 0 {
+		secrets = append(secrets, s.Secret)
+	}
+
+	if len(s.previousSecret) == 0 {
+		return secrets
+	}
+
+	if !s.previousSecretExpiry.IsZero() &&
+		s.clock().Now().After(s.previousSecretExpiry) {
+		// the grace period is over
+		s.previousSecret = nil
+		s.previousSecretExpiry = time.Time{}
+		return secrets
+	}
+
+	return append(secrets, s.previousSecret)
+}
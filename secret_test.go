@@ -0,0 +1,132 @@
+package sub
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotateSecretOnRenew(t *testing.T) {
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer hub.Close()
+
+	clock := newFakeClock()
+	var received []string
+	s := &Sub{
+		Hub:                 MustParseUrl(hub.URL),
+		Topic:               MustParseUrl("https://example.com/feed"),
+		Callback:            MustParseUrl("https://my-server.com/subscriber"),
+		Client:              http.DefaultClient,
+		Clock:               clock,
+		Secret:              []byte("old"),
+		state:               Subscribed,
+		RotateSecretOnRenew: true,
+		SecretGracePeriod:   time.Minute,
+		OnRenewLease:        func(s *Sub) {},
+		OnMessage: func(r *http.Request, body []byte) {
+			received = append(received, string(body))
+		},
+	}
+	defer s.CancelRenewal()
+
+	deliver := func(secret, body string) {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		r.Header.Set("X-Hub-Signature", SHA1.Sign([]byte(secret), []byte(body)))
+		s.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	// renew
+	err := s.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newSecret := string(s.Secret)
+	if newSecret == "old" {
+		t.Fatal("Expected the secret to be rotated")
+	}
+
+	// the hub signs with the old secret until it verified the new one
+	clock.Advance(time.Hour)
+	deliver("old", "before verification")
+
+	query := url.Values{}
+	query.Set("hub.topic", s.Topic.String())
+	query.Set("hub.mode", subscribeMode)
+	query.Set("hub.challenge", "challenge")
+	s.ServeHTTP(httptest.NewRecorder(),
+		httptest.NewRequest(http.MethodGet, "/?"+query.Encode(), nil))
+
+	deliver("old", "in flight")
+	deliver(newSecret, "new")
+
+	clock.Advance(2 * time.Minute)
+	deliver("old", "after grace period")
+
+	expected := "before verification,in flight,new"
+	if got := strings.Join(received, ","); got != expected {
+		t.Error("Expected", expected, "got", got)
+	}
+}
+
+func TestRotateSecretUnsigned(t *testing.T) {
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if _, ok := r.PostForm["hub.secret"]; ok {
+			t.Error("No secret should be sent")
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer hub.Close()
+
+	received := 0
+	s := &Sub{
+		Hub:                 MustParseUrl(hub.URL),
+		Topic:               MustParseUrl("https://example.com/feed"),
+		Callback:            MustParseUrl("https://my-server.com/subscriber"),
+		Client:              http.DefaultClient,
+		Clock:               newFakeClock(),
+		AllowUnsigned:       true,
+		RotateSecretOnRenew: true,
+		OnRenewLease:        func(s *Sub) {},
+		OnMessage: func(r *http.Request, body []byte) {
+			received++
+		},
+		OnError: func(err error) { t.Error(err) },
+	}
+	defer s.CancelRenewal()
+
+	subscribe := func() {
+		err := s.Subscribe()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		query := url.Values{}
+		query.Set("hub.topic", s.Topic.String())
+		query.Set("hub.mode", subscribeMode)
+		query.Set("hub.challenge", "challenge")
+		s.ServeHTTP(httptest.NewRecorder(),
+			httptest.NewRequest(http.MethodGet, "/?"+query.Encode(), nil))
+	}
+
+	// subscribe then renew
+	subscribe()
+	subscribe()
+
+	if len(s.Secret) != 0 {
+		t.Error("No secret should be generated when renewing")
+	}
+
+	s.ServeHTTP(httptest.NewRecorder(),
+		httptest.NewRequest(http.MethodPost, "/", strings.NewReader("unsigned")))
+	if received != 1 {
+		t.Error("Expected the unsigned message to be delivered")
+	}
+}
@@ -1,18 +1,11 @@
 package sub
 
 import (
-	"crypto/hmac"
-	"crypto/sha1"
-	"encoding/hex"
 	"io"
-	"io/ioutil"
 	"net/http"
 	"strconv"
-	"time"
 )
 
-const sha1Header = "sha1="
-
 // Implements http.Handler to handle incoming subscription requests.
 func (s *Sub) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
@@ -21,16 +14,10 @@ func (s *Sub) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 
 		// check topic
 		topic := values.Get("hub.topic")
-		if topic != s.Topic.String() {
+		if !s.isTopic(topic) {
 			// we received an invalid topic, this could be the doing of a
 			// malicious actor
-
-			if s.OnError != nil {
-				s.OnError(&RequestError{
-					Request: r,
-					Message: "Topic not found",
-				})
-			}
+			s.handleError(r, ErrTopicMismatch)
 
 			http.Error(rw, "Topic not found", http.StatusNotFound)
 			return
@@ -41,9 +28,21 @@ func (s *Sub) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 
 		mode := values.Get("hub.mode")
 		if mode == deniedMode {
-			// a subscription can be denied without requesting a change
-			// unsubscriptions can't be denied
-			s.State = Unsubscribed
+			// only subscribe requests and active subscriptions can be
+			// denied, anything else could be forged with a leaked callback url
+			pending, err := s.matchDenial()
+			if err != nil {
+				s.handleError(r, err)
+
+				http.Error(rw, "No matching request", http.StatusNotFound)
+				return
+			}
+
+			if pending != nil {
+				s.completePending(pending)
+			}
+			s.setState(Unsubscribed)
+			s.save()
 
 			// forward error
 			if s.OnError != nil {
@@ -58,56 +57,54 @@ func (s *Sub) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// reject all events if we aren't in a requesting state
-		if s.State != Requested {
-			// log
-			if s.OnError != nil {
-				s.OnError(&RequestError{
-					Request: r,
-					Message: "Received mode: " + mode +
-						" in state: " + s.State.String(),
-				})
-			}
+		if mode != subscribeMode && mode != unsubscribeMode {
+			// bad request
+			s.handleError(r, &ModeError{Mode: mode, State: s.State()})
 
-			// invalid state, message sent at wrong time
-			rw.WriteHeader(http.StatusOK)
+			http.Error(rw, "Unknown request type", http.StatusBadRequest)
+			return
+		}
+
+		// only verify what was requested, the callback url could have leaked
+		pending, err := s.matchPending(mode)
+		if err != nil {
+			s.handleError(r, err)
+
+			// tells the hub we don't agree with the action
+			http.Error(rw, "No matching request", http.StatusNotFound)
 			return
 		}
 
 		if mode == subscribeMode {
-			s.State = Subscribed
+			// get the lease time, assuming the requested one if the hub didn't
+			// say
+			rawLease := values.Get("hub.lease_seconds")
+			if rawLease == "" && pending.leaseSeconds > 0 {
+				rawLease = strconv.Itoa(pending.leaseSeconds)
+			}
 
-			// get the lease time
-			leaseStr := values.Get("hub.lease_seconds")
-			leaseSecs, err := strconv.ParseInt(leaseStr, 10, 64)
+			lease, err := s.parseLease(rawLease)
 			if err != nil {
-				if s.OnError != nil {
-					s.OnError(&RequestError{
-						Request: r,
-						Message: err.Error(),
-					})
-				}
+				s.handleError(r, err)
 
 				http.Error(rw, "Invalid lease seconds format", http.StatusBadRequest)
 				return
 			}
 
+			s.completePending(pending)
+			s.startSecretGracePeriod()
+
+			// the lease is set first so it is current when the state changes
+			s.setLeaseExpiry(s.clock().Now().Add(lease))
+			s.setState(Subscribed)
+
 			// schedule renewal
-			s.LeaseExpiry = time.Now().Add(time.Duration(leaseSecs) * time.Second)
 			s.scheduleRenewal()
-		} else if mode == unsubscribeMode {
-			s.State = Unsubscribed
+			s.save()
 		} else {
-			// bad request
-			if s.OnError != nil {
-				s.OnError(&RequestError{
-					Request: r,
-					Message: "Unknown message mode",
-				})
-			}
-
-			http.Error(rw, "Unknown request type", http.StatusBadRequest)
-			return
+			s.completePending(pending)
+			s.setState(Unsubscribed)
+			s.save()
 		}
 
 		// echo the challenge
@@ -121,91 +118,23 @@ func (s *Sub) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 
 	if r.Method == http.MethodPost {
 		// handle as a message
-		if s.OnMessage == nil {
-			// nothing to do
-			return
-		}
-
-		// check hmac
-		messageMac := r.Header.Get("X-Hub-Signature")
-
-		// messageMac starts with sha1=
-		ourHeader, digest := messageMac[0:len(sha1Header)],
-			messageMac[len(sha1Header):]
-
-		if ourHeader != sha1Header {
-			if s.OnError != nil {
-				s.OnError(&RequestError{
-					Request: r,
-					Message: "The header was invalid.",
-				})
-			}
-
-			http.Error(rw, "X-Hub-Signature header is invalid", http.StatusBadRequest)
-			return
-		}
-
-		// de-hex encode
-		decoded, err := hex.DecodeString(digest)
-		if err != nil {
-			if s.OnError != nil {
-				s.OnError(&RequestError{
-					Request: r,
-					Message: err.Error(),
-				})
-			}
-
-			http.Error(rw, err.Error(), http.StatusBadRequest)
-			return
-		}
-
-		message, err := ioutil.ReadAll(r.Body)
-		if err != nil {
-			if s.OnError != nil {
-				s.OnError(err)
-			}
-
-			// unable to read stream
-			http.Error(rw, "", http.StatusInternalServerError)
-			return
-		}
-
-		// this only checks the authenticity of the body. The headers could still
-		// be tampered with.
-		isValid := checkMAC(message, decoded, s.Secret)
-		if !isValid && s.OnError != nil {
-			// invalid hmac signature
-			s.OnError(&RequestError{
-				Request: r,
-				Message: "Invalid HMAC Signature",
-			})
-		}
-
-		if isValid {
-			// call callback
-			s.OnMessage(r, message)
-		}
-
-		rw.WriteHeader(http.StatusOK)
+		s.handleMessage(rw, r)
 		return
 	}
 
 	// unknown request
-	if s.OnError != nil {
-		s.OnError(&RequestError{
-			Request: r,
-			Message: "Unknown request",
-		})
-	}
+	s.handleError(r, ErrUnknownRequest)
 	http.Error(rw, "Unknown request", http.StatusBadRequest)
 }
 
-// A helper function which computes and securely compares the sha1 mac of a
-// message.
-func checkMAC(message, messageMac, key []byte) bool {
-	mac := hmac.New(sha1.New, key)
-	mac.Write(message)
-	calculatedMac := mac.Sum(nil)
+// Sends an error caused by a request to OnError wrapped in a RequestError.
+func (s *Sub) handleError(r *http.Request, err error) {
+	if s.OnError == nil {
+		return
+	}
 
-	return hmac.Equal(messageMac, calculatedMac)
+	s.OnError(&RequestError{
+		Request: r,
+		Err:     err,
+	})
 }
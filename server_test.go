@@ -4,10 +4,12 @@ import (
 	"crypto/hmac"
 	"crypto/sha1"
 	"encoding/hex"
+	"errors"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"reflect"
 	"strconv"
 	"strings"
 	"testing"
@@ -18,8 +20,8 @@ func TestSubscriptionVerification(t *testing.T) {
 	// initialize subscription
 	s := &Sub{
 		Topic: MustParseUrl("https://example.com/awesome-stuff/feed"),
-		State: Requested,
 	}
+	s.request(subscribeMode, 0)
 
 	// start listening on server
 	ts := httptest.NewServer(s)
@@ -60,18 +62,274 @@ func TestSubscriptionVerification(t *testing.T) {
 	}
 
 	// check that the subscription's state was updated
-	if s.State != Subscribed {
+	if s.State() != Subscribed {
 		t.Error("Subscription in unexpected state. Was in state: " +
-			s.State.String())
+			s.State().String())
 	}
 
 	// check that the lease time was updated
-	realLease := int64(s.LeaseExpiry.Sub(time.Now()).Seconds())
+	realLease := int64(s.LeaseExpiry().Sub(time.Now()).Seconds())
 	if realLease < leaseTime-10 || realLease > leaseTime+10 {
 		t.Error("Expected: ", leaseTime, "Real: ", realLease)
 	}
 }
 
+func TestVerificationOfOriginalTopic(t *testing.T) {
+	s := &Sub{
+		Topic:         MustParseUrl("https://example.com/feed"),
+		OriginalTopic: MustParseUrl("http://example.com/old-feed"),
+	}
+	s.request(unsubscribeMode, 0)
+
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	subscriber := MustParseUrl(ts.URL)
+	query := url.Values{}
+	query.Add("hub.topic", s.OriginalTopic.String())
+	query.Add("hub.mode", unsubscribeMode)
+	query.Add("hub.challenge", "challenge")
+	subscriber.RawQuery = query.Encode()
+
+	resp, err := http.Get(subscriber.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		t.Error("The status code is bad", resp.StatusCode)
+	}
+
+	if s.State() != Unsubscribed {
+		t.Error("Subscription in unexpected state. Was in state: " +
+			s.State().String())
+	}
+}
+
+func TestVerificationWithoutLease(t *testing.T) {
+	clock := newFakeClock()
+	s := &Sub{
+		Topic:        MustParseUrl("https://example.com/feed"),
+		Clock:        clock,
+		DefaultLease: time.Hour,
+		OnRenewLease: func(s *Sub) {},
+	}
+	s.request(subscribeMode, 0)
+
+	query := url.Values{}
+	query.Set("hub.topic", s.Topic.String())
+	query.Set("hub.mode", subscribeMode)
+	query.Set("hub.challenge", "challenge")
+
+	rw := httptest.NewRecorder()
+	s.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/?"+query.Encode(), nil))
+
+	if rw.Code != http.StatusOK || rw.Body.String() != "challenge" {
+		t.Error("Unexpected response", rw.Code, rw.Body.String())
+	}
+
+	if !s.LeaseExpiry().Equal(clock.Now().Add(time.Hour)) {
+		t.Error("Expected the default lease, got", s.LeaseExpiry().Sub(clock.Now()))
+	}
+}
+
+func TestVerificationMatchesPendingRequest(t *testing.T) {
+	clock := newFakeClock()
+	var gotErr error
+	s := &Sub{
+		Topic:          MustParseUrl("https://example.com/feed"),
+		Clock:          clock,
+		PendingTimeout: time.Minute,
+		OnRenewLease:   func(s *Sub) {},
+		OnError:        func(err error) { gotErr = err },
+	}
+
+	verify := func(mode string) int {
+		gotErr = nil
+
+		query := url.Values{}
+		query.Set("hub.topic", s.Topic.String())
+		query.Set("hub.mode", mode)
+		query.Set("hub.challenge", "challenge")
+
+		rw := httptest.NewRecorder()
+		s.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/?"+query.Encode(), nil))
+		return rw.Code
+	}
+
+	// nothing was requested
+	if code := verify(subscribeMode); code != http.StatusNotFound ||
+		!errors.Is(gotErr, ErrUnexpectedMode) {
+		t.Error("Expected an unrequested verification to be rejected", code, gotErr)
+	}
+
+	// a stale subscribe verification after asking to unsubscribe
+	s.request(unsubscribeMode, 0)
+
+	var intentErr *IntentError
+	if code := verify(subscribeMode); code != http.StatusNotFound ||
+		!errors.As(gotErr, &intentErr) || intentErr.Pending != unsubscribeMode {
+		t.Error("Expected a stale verification to be rejected", code, gotErr)
+	}
+
+	if s.State() != Requested {
+		t.Error("The state shouldn't change, got", s.State())
+	}
+
+	if code := verify(unsubscribeMode); code != http.StatusOK || gotErr != nil {
+		t.Error("Expected the pending request to be verified", code, gotErr)
+	}
+
+	// each request is verified once
+	if code := verify(unsubscribeMode); code != http.StatusNotFound {
+		t.Error("Expected a replayed verification to be rejected", code)
+	}
+
+	// the requested lease is assumed when the hub doesn't send one
+	s.request(subscribeMode, 600)
+	clock.Advance(30 * time.Second)
+	if code := verify(subscribeMode); code != http.StatusOK {
+		t.Error("Expected the pending request to be verified", code, gotErr)
+	}
+
+	if !s.LeaseExpiry().Equal(clock.Now().Add(10 * time.Minute)) {
+		t.Error("Expected the requested lease, got", s.LeaseExpiry().Sub(clock.Now()))
+	}
+
+	// verifications arriving too late are rejected
+	s.request(unsubscribeMode, 0)
+	clock.Advance(2 * time.Minute)
+	if code := verify(unsubscribeMode); code != http.StatusNotFound ||
+		!errors.Is(gotErr, ErrIntentExpired) {
+		t.Error("Expected an expired verification to be rejected", code, gotErr)
+	}
+}
+
+func TestVerificationOfDenial(t *testing.T) {
+	clock := newFakeClock()
+	var gotErr error
+	s := &Sub{
+		Topic:        MustParseUrl("https://example.com/feed"),
+		Clock:        clock,
+		OnRenewLease: func(s *Sub) {},
+		OnError:      func(err error) { gotErr = err },
+	}
+
+	deny := func() int {
+		gotErr = nil
+
+		query := url.Values{}
+		query.Set("hub.topic", s.Topic.String())
+		query.Set("hub.mode", deniedMode)
+		query.Set("hub.reason", "go away")
+
+		rw := httptest.NewRecorder()
+		s.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/?"+query.Encode(), nil))
+		return rw.Code
+	}
+
+	// a spoofed denial while nothing was requested
+	var intentErr *IntentError
+	if code := deny(); code != http.StatusNotFound ||
+		!errors.As(gotErr, &intentErr) || intentErr.Mode != deniedMode {
+		t.Error("Expected a spoofed denial to be rejected", code, gotErr)
+	}
+
+	// unsubscriptions can't be denied
+	s.request(unsubscribeMode, 0)
+	s.setLeaseExpiry(clock.Now().Add(time.Hour))
+	if code := deny(); code != http.StatusNotFound || !errors.Is(gotErr, ErrUnexpectedMode) {
+		t.Error("Expected a denied unsubscription to be rejected", code, gotErr)
+	}
+
+	if s.State() != Requested {
+		t.Error("A rejected denial changed the state to", s.State())
+	}
+
+	// a pending subscription
+	s.request(subscribeMode, 0)
+	deny()
+
+	var deniedErr *DeniedError
+	if !errors.As(gotErr, &deniedErr) || deniedErr.Reason != "go away" {
+		t.Error("Expected the subscription to be denied, got", gotErr)
+	}
+
+	if s.State() != Unsubscribed {
+		t.Error("Expected the subscription to be unsubscribed, got", s.State())
+	}
+
+	// the hub cancels an active subscription
+	s.setLeaseExpiry(clock.Now().Add(time.Hour))
+	s.setState(Subscribed)
+	s.scheduleRenewal()
+	deny()
+
+	if !errors.As(gotErr, &deniedErr) {
+		t.Error("Expected the subscription to be cancelled, got", gotErr)
+	}
+
+	if s.State() != Unsubscribed || s.CancelRenewal() {
+		t.Error("Expected the subscription to end, got", s.State())
+	}
+}
+
+func TestStateChange(t *testing.T) {
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer hub.Close()
+
+	var changes []State
+	s := &Sub{
+		Hub:          MustParseUrl(hub.URL),
+		Topic:        MustParseUrl("https://example.com/feed"),
+		Callback:     MustParseUrl("https://my-server.com/subscriber"),
+		Client:       http.DefaultClient,
+		Clock:        newFakeClock(),
+		OnRenewLease: func(s *Sub) {},
+	}
+	s.OnStateChange = func(old, new State) {
+		if len(changes) > 0 && changes[len(changes)-1] != old {
+			t.Error("Unexpected old state", old)
+		}
+
+		// the accessors can be used from the hook
+		if s.State() != new {
+			t.Error("Expected the new state to be visible, got", s.State())
+		}
+
+		changes = append(changes, new)
+	}
+
+	verify := func(mode string) {
+		query := url.Values{}
+		query.Set("hub.topic", s.Topic.String())
+		query.Set("hub.mode", mode)
+		query.Set("hub.challenge", "challenge")
+
+		rw := httptest.NewRecorder()
+		s.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/?"+query.Encode(), nil))
+	}
+
+	err := s.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify(subscribeMode)
+
+	err = s.Unsubscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify(unsubscribeMode)
+
+	expected := []State{Requested, Subscribed, Requested, Unsubscribed}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Error("Expected", expected, "got", changes)
+	}
+}
+
 func TestMessageReceive(t *testing.T) {
 	expectedBody := "This is a secure message!"
 
@@ -0,0 +1,99 @@
+package sub
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// The hash algorithm a hub used to sign a message in the X-Hub-Signature
+// header. Algorithms are ordered from weakest to strongest.
+type SignatureAlgorithm int
+
+const (
+	SHA1 SignatureAlgorithm = iota
+	SHA256
+	SHA384
+	SHA512
+)
+
+var signatureAlgorithms = []struct {
+	name string
+	hash func() hash.Hash
+}{
+	SHA1:   {"sha1", sha1.New},
+	SHA256: {"sha256", sha256.New},
+	SHA384: {"sha384", sha512.New384},
+	SHA512: {"sha512", sha512.New},
+}
+
+// Returns the name of the algorithm as used in the X-Hub-Signature header.
+func (a SignatureAlgorithm) String() string {
+	if a < 0 || int(a) >= len(signatureAlgorithms) {
+		return "unknown"
+	}
+
+	return signatureAlgorithms[a].name
+}
+
+// Signs message with key, returning a value for the X-Hub-Signature header.
+func (a SignatureAlgorithm) Sign(key, message []byte) string {
+	mac := hmac.New(signatureAlgorithms[a].hash, key)
+	mac.Write(message)
+
+	return a.String() + "=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Looks up an algorithm by its name in the X-Hub-Signature header.
+func signatureAlgorithmByName(name string) (SignatureAlgorithm, bool) {
+	for alg, info := range signatureAlgorithms {
+		if info.name == name {
+			return SignatureAlgorithm(alg), true
+		}
+	}
+
+	return 0, false
+}
+
+// This error is returned when a message is signed with an algorithm which
+// isn't known or is weaker than Sub.MinSignatureAlgorithm.
+type UnsupportedAlgorithmError struct {
+	Algorithm string
+}
+
+func (e *UnsupportedAlgorithmError) Error() string {
+	return "unsupported signature algorithm: " + e.Algorithm
+}
+
+// Parses a X-Hub-Signature header of the form algorithm=hexdigest. The
+// algorithm must be at least as strong as min.
+func parseSignature(header string, min SignatureAlgorithm) (SignatureAlgorithm, []byte, error) {
+	if header == "" {
+		return 0, nil, ErrMissingSignature
+	}
+
+	idx := strings.Index(header, "=")
+	if idx < 0 {
+		return 0, nil, ErrMalformedSignature
+	}
+
+	name, digest := header[:idx], header[idx+1:]
+
+	alg, ok := signatureAlgorithmByName(strings.ToLower(name))
+	if !ok || alg < min {
+		return 0, nil, &UnsupportedAlgorithmError{Algorithm: name}
+	}
+
+	// de-hex encode
+	decoded, err := hex.DecodeString(digest)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: %v", ErrMalformedSignature, err)
+	}
+
+	return alg, decoded, nil
+}
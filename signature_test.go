@@ -0,0 +1,237 @@
+package sub
+
+import (
+	"crypto/hmac"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseSignature(t *testing.T) {
+	tests := []struct {
+		header string
+		min    SignatureAlgorithm
+		alg    SignatureAlgorithm
+		valid  bool
+	}{
+		{header: "sha1=00ff", alg: SHA1, valid: true},
+		{header: "sha256=00ff", alg: SHA256, valid: true},
+		{header: "sha384=00ff", alg: SHA384, valid: true},
+		{header: "SHA512=00ff", alg: SHA512, valid: true},
+		{header: "sha1=00ff", min: SHA256},
+		{header: "md5=00ff"},
+		{header: "sha1"},
+		{header: ""},
+		{header: "sha1=nothex"},
+	}
+
+	for _, test := range tests {
+		alg, digest, err := parseSignature(test.header, test.min)
+		if test.valid != (err == nil) {
+			t.Error(test.header, "unexpected error", err)
+			continue
+		}
+
+		if !test.valid {
+			continue
+		}
+
+		if alg != test.alg {
+			t.Error(test.header, "got algorithm", alg, "expected", test.alg)
+		}
+
+		if string(digest) != "\x00\xff" {
+			t.Error(test.header, "got incorrect digest", digest)
+		}
+	}
+}
+
+func TestMessageReceiveAlgorithms(t *testing.T) {
+	secret := []byte("secret")
+	body := "This is a secure message!"
+
+	for alg, info := range signatureAlgorithms {
+		received := false
+		s := &Sub{
+			OnMessage: func(req *http.Request, rawBody []byte) {
+				received = true
+			},
+			OnError: func(err error) {
+				t.Error(err)
+			},
+			Secret: secret,
+		}
+
+		ts := httptest.NewServer(s)
+
+		mac := hmac.New(info.hash, secret)
+		mac.Write([]byte(body))
+
+		req, err := http.NewRequest(http.MethodPost, ts.URL, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Hub-Signature", SignatureAlgorithm(alg).String()+"="+
+			string(toHex(mac.Sum(nil))))
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != http.StatusOK || !received {
+			t.Error(info.name, "message wasn't received", resp.StatusCode)
+		}
+
+		ts.Close()
+	}
+}
+
+func TestMessageWeakAlgorithm(t *testing.T) {
+	var gotErr error
+	s := &Sub{
+		OnMessage: func(req *http.Request, rawBody []byte) {
+			t.Error("The message should have been rejected")
+		},
+		OnError: func(err error) {
+			gotErr = err
+		},
+		Secret:                []byte("secret"),
+		MinSignatureAlgorithm: SHA256,
+	}
+
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL, strings.NewReader("body"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Hub-Signature", "sha1=00")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Error("Unexpected status code", resp.StatusCode)
+	}
+
+	var algErr *UnsupportedAlgorithmError
+	if !errors.As(gotErr, &algErr) || algErr.Algorithm != "sha1" {
+		t.Error("Expected an UnsupportedAlgorithmError, got", gotErr)
+	}
+}
+
+func TestSign(t *testing.T) {
+	header := SHA256.Sign([]byte("key"), []byte("message"))
+
+	alg, digest, err := parseSignature(header, SHA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := newVerifyingReader(strings.NewReader("message"), -1, alg, digest,
+		[][]byte{[]byte("key")})
+	_, err = ioutil.ReadAll(body)
+	if alg != SHA256 || err != nil {
+		t.Error("The signature doesn't verify", header, err)
+	}
+}
+
+func TestSignaturePolicy(t *testing.T) {
+	body := "body"
+	secret := []byte("secret")
+
+	tests := []struct {
+		name          string
+		secret        []byte
+		allowUnsigned bool
+		header        string
+		code          int
+		err           error
+	}{
+		{
+			name:   "missing",
+			secret: secret,
+			code:   http.StatusOK,
+			err:    ErrMissingSignature,
+		},
+		{
+			name:          "missing with unsigned allowed",
+			secret:        secret,
+			allowUnsigned: true,
+			code:          http.StatusOK,
+			err:           ErrMissingSignature,
+		},
+		{
+			name:   "without digest",
+			secret: secret,
+			header: "sha1",
+			code:   http.StatusBadRequest,
+			err:    ErrMalformedSignature,
+		},
+		{
+			name:   "short digest",
+			secret: secret,
+			header: "sha1=a",
+			code:   http.StatusBadRequest,
+			err:    ErrMalformedSignature,
+		},
+		{
+			name:   "wrong",
+			secret: secret,
+			header: SHA1.Sign([]byte("wrong"), []byte(body)),
+			code:   http.StatusOK,
+			err:    ErrBadSignature,
+		},
+		{
+			name:   "no secret",
+			header: SHA1.Sign(nil, []byte(body)),
+			code:   http.StatusOK,
+			err:    ErrNoSecret,
+		},
+		{
+			name:          "unsigned",
+			allowUnsigned: true,
+			code:          http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		received := false
+		var gotErr error
+		s := &Sub{
+			Secret:        test.secret,
+			AllowUnsigned: test.allowUnsigned,
+			OnMessage: func(req *http.Request, rawBody []byte) {
+				received = true
+			},
+			OnError: func(err error) { gotErr = err },
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		if test.header != "" {
+			req.Header.Set("X-Hub-Signature", test.header)
+		}
+
+		rw := httptest.NewRecorder()
+		s.ServeHTTP(rw, req)
+
+		if rw.Code != test.code {
+			t.Errorf("%s: expected %d, got %d", test.name, test.code, rw.Code)
+		}
+
+		if test.err == nil && gotErr != nil || !errors.Is(gotErr, test.err) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.err, gotErr)
+		}
+
+		if received != (test.err == nil) {
+			t.Errorf("%s: unexpected delivery %v", test.name, received)
+		}
+	}
+}
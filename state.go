@@ -2,6 +2,12 @@
 
 package sub
 
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
 type State int
 
 const (
@@ -14,4 +20,169 @@ const (
 	subscribeMode   = "subscribe"
 	unsubscribeMode = "unsubscribe"
 	deniedMode      = "denied"
+	publishMode     = "publish"
 )
+
+// Encodes the state by name.
+func (i State) MarshalText() ([]byte, error) {
+	return []byte(i.String()), nil
+}
+
+// Decodes a state encoded by MarshalText.
+func (i *State) UnmarshalText(text []byte) error {
+	for state := Unsubscribed; state <= Subscribed; state++ {
+		if state.String() == string(text) {
+			*i = state
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown state %q", text)
+}
+
+// Returns the current state of the subscription.
+func (s *Sub) State() State {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	return s.state
+}
+
+// Returns the time at which the lease will expire.
+func (s *Sub) LeaseExpiry() time.Time {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	return s.leaseExpiry
+}
+
+// Changes the state, calling OnStateChange if it is different.
+func (s *Sub) setState(state State) {
+	s.stateLock.Lock()
+	old := s.state
+	s.state = state
+	s.stateLock.Unlock()
+
+	if old != state && s.OnStateChange != nil {
+		s.OnStateChange(old, state)
+	}
+}
+
+// Changes the time at which the lease will expire.
+func (s *Sub) setLeaseExpiry(expiry time.Time) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	s.leaseExpiry = expiry
+}
+
+// The default of Sub.PendingTimeout.
+const defaultPendingTimeout = time.Hour
+
+// A subscribe or unsubscribe request which the hub hasn't verified yet.
+type pendingRequest struct {
+	mode string
+
+	// The requested lease in seconds, zero when the hub picks.
+	leaseSeconds int
+
+	sent time.Time
+}
+
+// Remembers a request which is about to be sent to the hub, replacing any
+// earlier one, and moves to the Requested state.
+func (s *Sub) request(mode string, leaseSeconds int) {
+	s.stateLock.Lock()
+	s.pending = &pendingRequest{
+		mode:         mode,
+		leaseSeconds: leaseSeconds,
+		sent:         s.clock().Now(),
+	}
+	s.stateLock.Unlock()
+
+	s.setState(Requested)
+}
+
+// Returns the pending request matching a verification with mode. Otherwise, a
+// ModeError is returned when nothing is pending and an IntentError when the
+// pending request is for a different mode or timed out.
+func (s *Sub) matchPending(mode string) (*pendingRequest, error) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	pending := s.pending
+	if pending == nil {
+		return nil, &ModeError{Mode: mode, State: s.state}
+	}
+
+	if s.clock().Now().After(pending.sent.Add(s.pendingTimeout())) {
+		// the hub won't be able to confirm it anymore
+		s.pending = nil
+
+		return nil, &IntentError{
+			Mode:    mode,
+			Pending: pending.mode,
+			Sent:    pending.sent,
+			Err:     ErrIntentExpired,
+		}
+	}
+
+	if pending.mode != mode {
+		// the real verification may still arrive
+		return nil, &IntentError{
+			Mode:    mode,
+			Pending: pending.mode,
+			Sent:    pending.sent,
+			Err:     ErrUnexpectedMode,
+		}
+	}
+
+	return pending, nil
+}
+
+// Returns the pending subscribe request a denial refers to. A denial of an
+// active lease, without a pending request, cancels the subscription and nil is
+// returned. Otherwise, an IntentError describes why the denial doesn't match.
+func (s *Sub) matchDenial() (*pendingRequest, error) {
+	pending, err := s.matchPending(subscribeMode)
+	if err == nil {
+		return pending, nil
+	}
+
+	var intentErr *IntentError
+	isIntentErr := errors.As(err, &intentErr)
+	unsubscribing := isIntentErr && intentErr.Pending == unsubscribeMode &&
+		errors.Is(err, ErrUnexpectedMode)
+
+	if !unsubscribing && s.clock().Now().Before(s.LeaseExpiry()) {
+		// the hub cancels the subscription
+		return nil, nil
+	}
+
+	if isIntentErr {
+		intentErr.Mode = deniedMode
+		return nil, intentErr
+	}
+
+	// nothing was requested
+	return nil, &IntentError{Mode: deniedMode, Err: ErrUnexpectedMode}
+}
+
+// Returns how long a request waits for verification.
+func (s *Sub) pendingTimeout() time.Duration {
+	if s.PendingTimeout <= 0 {
+		return defaultPendingTimeout
+	}
+
+	return s.PendingTimeout
+}
+
+// Forgets the pending request once it was verified. A newer request is kept.
+func (s *Sub) completePending(pending *pendingRequest) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	if s.pending == pending {
+		s.pending = nil
+	}
+}
@@ -0,0 +1,277 @@
+package sub
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Returned by Store.Get when there is no record for a key.
+var ErrNotFound = errors.New("not found")
+
+// The persisted state of a subscription. It holds everything needed to resume
+// a subscription after a restart without subscribing again.
+type Record struct {
+	Topic         string
+	OriginalTopic string   `json:",omitempty"`
+	Hub           string   `json:",omitempty"`
+	Hubs          []string `json:",omitempty"`
+	Callback      string
+	Secret        string
+
+	// The secret replaced by Sub.RotateSecret and when it stops being
+	// accepted. The expiry is zero until the hub verified the new secret.
+	PreviousSecret       string `json:",omitempty"`
+	PreviousSecretExpiry time.Time
+
+	State       State
+	LeaseExpiry time.Time
+
+	// The keys remembered by Sub.Dedup.
+	Seen []string `json:",omitempty"`
+}
+
+// Stores records of subscriptions by key. Implementations must be safe for
+// concurrent use.
+type Store interface {
+	// Returns the record stored at key or ErrNotFound.
+	Get(key string) (*Record, error)
+
+	// Replaces the record stored at key.
+	Put(key string, record *Record) error
+
+	// Removes the record stored at key. Deleting a missing key isn't an error.
+	Delete(key string) error
+}
+
+// A Store which keeps all records in a single JSON file. The file is replaced
+// atomically on every change.
+type FileStore struct {
+	path string
+
+	lock    sync.Mutex
+	records map[string]*Record
+}
+
+// Opens the store at path, loading any existing records. The file is created
+// on the first change.
+func OpenFileStore(path string) (*FileStore, error) {
+	store := &FileStore{
+		path:    path,
+		records: map[string]*Record{},
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(contents, &store.records)
+	if err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+func (fs *FileStore) Get(key string) (*Record, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	record, ok := fs.records[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	copied := *record
+	return &copied, nil
+}
+
+func (fs *FileStore) Put(key string, record *Record) error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	copied := *record
+	fs.records[key] = &copied
+
+	return fs.write()
+}
+
+func (fs *FileStore) Delete(key string) error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	if _, ok := fs.records[key]; !ok {
+		return nil
+	}
+
+	delete(fs.records, key)
+	return fs.write()
+}
+
+// Writes all records to a temporary file then moves it over the store so the
+// store is never left half written. The lock must be held.
+func (fs *FileStore) write() error {
+	contents, err := json.MarshalIndent(fs.records, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(fs.path), filepath.Base(fs.path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	// the secrets are in here
+	err = tmp.Chmod(0600)
+	if err == nil {
+		_, err = tmp.Write(contents)
+	}
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), fs.path)
+}
+
+// Returns a snapshot of the subscription which can be persisted in a Store.
+func (s *Sub) Record() *Record {
+	s.stateLock.Lock()
+	record := &Record{
+		Secret:               string(s.Secret),
+		PreviousSecret:       string(s.previousSecret),
+		PreviousSecretExpiry: s.previousSecretExpiry,
+		State:                s.state,
+		LeaseExpiry:          s.leaseExpiry,
+	}
+
+	if s.Hub != nil {
+		record.Hub = s.Hub.String()
+	}
+
+	for _, hub := range s.Hubs {
+		record.Hubs = append(record.Hubs, hub.String())
+	}
+	s.stateLock.Unlock()
+
+	if s.Dedup != nil {
+		record.Seen = s.Dedup.Keys()
+	}
+
+	if s.Topic != nil {
+		record.Topic = s.Topic.String()
+	}
+
+	if s.OriginalTopic != nil {
+		record.OriginalTopic = s.OriginalTopic.String()
+	}
+
+	if s.Callback != nil {
+		record.Callback = s.Callback.String()
+	}
+
+	return record
+}
+
+// Restores the subscription from a record. If the record is of an active
+// subscription, renewal is scheduled as if the hub just verified it. A
+// subscription whose lease lapsed is restored as Unsubscribed.
+func (s *Sub) Restore(record *Record) error {
+	var err error
+	parse := func(raw string) *url.URL {
+		if raw == "" || err != nil {
+			return nil
+		}
+
+		var parsed *url.URL
+		parsed, err = url.Parse(raw)
+		return parsed
+	}
+
+	topic := parse(record.Topic)
+	originalTopic := parse(record.OriginalTopic)
+	hub := parse(record.Hub)
+	callback := parse(record.Callback)
+
+	hubs := []*url.URL{}
+	for _, rawHub := range record.Hubs {
+		if hub := parse(rawHub); hub != nil {
+			hubs = append(hubs, hub)
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	s.Topic = topic
+	s.OriginalTopic = originalTopic
+	s.Callback = callback
+
+	s.stateLock.Lock()
+	s.Hub = hub
+	s.Hubs = hubs
+	s.Secret = []byte(record.Secret)
+	s.previousSecret = []byte(record.PreviousSecret)
+	s.previousSecretExpiry = record.PreviousSecretExpiry
+	s.stateLock.Unlock()
+
+	if s.Dedup != nil {
+		s.Dedup.Load(record.Seen)
+	}
+
+	state := record.State
+	if state == Subscribed && !s.clock().Now().Before(record.LeaseExpiry) {
+		// the lease lapsed while the subscription was stored
+		state = Unsubscribed
+	}
+
+	s.setLeaseExpiry(record.LeaseExpiry)
+	s.setState(state)
+
+	if state == Subscribed {
+		s.scheduleRenewal()
+	}
+
+	return nil
+}
+
+// Returns true unless the subscription is active and its lease lasts longer
+// than margin.
+func (s *Sub) NeedsRenewal(margin time.Duration) bool {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	return s.state != Subscribed || s.clock().Now().Add(margin).After(s.leaseExpiry)
+}
+
+// Persists the subscription in s.Store, if there is one. Failures are sent to
+// OnError.
+func (s *Sub) save() {
+	if s.Store == nil {
+		return
+	}
+
+	key := s.StoreKey
+	if key == "" && s.Callback != nil {
+		key = s.Callback.String()
+	}
+
+	err := s.Store.Put(key, s.Record())
+	if err != nil && s.OnError != nil {
+		s.OnError(err)
+	}
+}
@@ -0,0 +1,195 @@
+package sub
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Returns the path of a file in a new temporary directory and a function to
+// remove the directory.
+func tempStorePath(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return filepath.Join(dir, "subs.json"), func() { os.RemoveAll(dir) }
+}
+
+func TestFileStore(t *testing.T) {
+	path, cleanup := tempStorePath(t)
+	defer cleanup()
+
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = store.Get("missing")
+	if err != ErrNotFound {
+		t.Error("Expected ErrNotFound, got", err)
+	}
+
+	record := &Record{
+		Topic:       "https://example.com/feed",
+		Hub:         "https://hub.example.com/",
+		Hubs:        []string{"https://hub.example.com/"},
+		Callback:    "https://me.example.com/callback",
+		Secret:      "secret",
+		State:       Subscribed,
+		LeaseExpiry: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	err = store.Put("feed", record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode().Perm() != 0600 {
+		t.Error("The store should only be readable by its owner", info.Mode())
+	}
+
+	// reopen to read from disk
+	store, err = OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.Get("feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, record) {
+		t.Errorf("Got %#v, expected %#v", got, record)
+	}
+
+	err = store.Delete("feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = store.Get("feed")
+	if err != ErrNotFound {
+		t.Error("Expected ErrNotFound after delete, got", err)
+	}
+}
+
+func TestRecordRestore(t *testing.T) {
+	s := &Sub{
+		Topic:       MustParseUrl("https://example.com/feed"),
+		Hub:         MustParseUrl("https://hub.example.com/"),
+		Hubs:        []*url.URL{MustParseUrl("https://hub.example.com/")},
+		Callback:    MustParseUrl("https://me.example.com/callback"),
+		Secret:      []byte("secret"),
+		state:       Subscribed,
+		leaseExpiry: time.Now().Add(time.Hour),
+	}
+
+	renewed := make(chan struct{}, 1)
+	restored := &Sub{
+		OnRenewLease: func(s *Sub) {
+			renewed <- struct{}{}
+		},
+	}
+
+	err := restored.Restore(s.Record())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.CancelRenewal()
+
+	if !reflect.DeepEqual(restored.Record(), s.Record()) {
+		t.Errorf("Got %#v, expected %#v", restored.Record(), s.Record())
+	}
+
+	if restored.NeedsRenewal(time.Minute) {
+		t.Error("A restored subscription with an hour left shouldn't need renewal")
+	}
+
+	if !restored.NeedsRenewal(2 * time.Hour) {
+		t.Error("A subscription expiring within the margin needs renewal")
+	}
+
+	if restored.cancelRenew == nil {
+		t.Error("Renewal should have been scheduled")
+	}
+}
+
+func TestRestoreExpired(t *testing.T) {
+	clock := newFakeClock()
+	s := &Sub{
+		Topic:       MustParseUrl("https://example.com/feed"),
+		Callback:    MustParseUrl("https://me.example.com/callback"),
+		state:       Subscribed,
+		leaseExpiry: clock.Now().Add(-time.Minute),
+	}
+
+	restored := &Sub{
+		Clock: clock,
+		OnRenewLease: func(s *Sub) {
+			t.Error("A lapsed lease shouldn't be renewed")
+		},
+	}
+
+	err := restored.Restore(s.Record())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if restored.State() != Unsubscribed {
+		t.Error("Expected the lapsed subscription to be unsubscribed, got", restored.State())
+	}
+
+	if restored.CancelRenewal() {
+		t.Error("No renewal should have been scheduled")
+	}
+}
+
+func TestSaveOnVerification(t *testing.T) {
+	path, cleanup := tempStorePath(t)
+	defer cleanup()
+
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Sub{
+		Topic:        MustParseUrl("https://example.com/feed"),
+		Store:        store,
+		StoreKey:     "feed",
+		OnRenewLease: func(s *Sub) {},
+	}
+	s.request(subscribeMode, 0)
+	defer s.CancelRenewal()
+
+	query := url.Values{}
+	query.Set("hub.topic", s.Topic.String())
+	query.Set("hub.mode", subscribeMode)
+	query.Set("hub.lease_seconds", "3600")
+
+	req := httptest.NewRequest(http.MethodGet, "/?"+query.Encode(), nil)
+	s.ServeHTTP(httptest.NewRecorder(), req)
+
+	record, err := store.Get("feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if record.State != Subscribed || !record.LeaseExpiry.Equal(s.LeaseExpiry()) {
+		t.Errorf("The verified subscription wasn't saved: %#v", record)
+	}
+}
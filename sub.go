@@ -12,6 +12,7 @@ package sub
 
 import (
 	"crypto/rand"
+	"io"
 	"net/http"
 	"net/url"
 	"sync"
@@ -23,9 +24,20 @@ type Sub struct {
 	// The url of the topic which this subscription receives events for.
 	Topic *url.URL
 
+	// The url of the topic before Sub.Discover() replaced Topic with the
+	// canonical url advertised by the topic. Verification requests for either
+	// topic are accepted.
+	OriginalTopic *url.URL
+
 	// The url of the hub. This can be discovered using Sub.Discover()
 	Hub *url.URL
 
+	// Every hub which can be used for the topic, usually filled by
+	// Sub.Discover(). When a subscription request to Hub fails, the other hubs
+	// are tried in order and Hub is replaced by the first hub which accepts the
+	// request.
+	Hubs []*url.URL
+
 	// The url which is provided to the hub during subscription and renewal.
 	// This url is allowed to contain query parameters.
 	Callback *url.URL
@@ -34,15 +46,82 @@ type Sub struct {
 	// called. The body on request is always closed.
 	OnMessage func(request *http.Request, body []byte)
 
+	// Called with the entries of messages which are Atom, RSS 2.0 or JSON Feed
+	// documents, see ParseFeed. Other messages and feeds which fail to parse
+	// are passed to OnMessage instead.
+	OnEntries func(request *http.Request, entries []Entry)
+
+	// When set, the topic is fetched with Client when a message without
+	// content arrives. Some hubs send such "thin pings" instead of the
+	// content. The fetched content is delivered like a message, unless the
+	// topic wasn't modified since the last fetch. It doesn't apply to
+	// OnMessageStream.
+	FetchThinPings bool
+
+	// When set, messages which were delivered before are dropped. Feeds are
+	// compared by the ids of their entries and OnEntries only gets the new
+	// ones, or also the changed ones with Deduplicator.IncludeChanged. Other
+	// messages are compared by their body, unless Deduplicator.EntriesOnly is
+	// set. The remembered keys are saved in Store.
+	Dedup *Deduplicator
+
+	// An alternative to OnMessage for large messages, called instead of it and
+	// OnEntries when set. The signature of body is verified while it is read:
+	// reading the end of body returns io.EOF when it is authentic and
+	// ErrBadSignature otherwise, so nothing read should be trusted before
+	// that. Whatever the callback doesn't read is verified after it returns.
+	// When it returns an error, the hub is asked to deliver the message again.
+	OnMessageStream func(request *http.Request, body io.Reader) error
+
+	// The largest message accepted in bytes. Larger messages are rejected
+	// with 413 Request Entity Too Large. 10 MiB is used when zero and
+	// messages of any size are accepted when negative.
+	MaxBodySize int64
+
 	// When a broken message is handled or the hub cancels our subscription,
-	// this callback is called.
+	// this callback is called. Cancellations are sent as a DeniedError.
 	OnError func(err error)
 
 	// Called when it is time to renew the lease. This can be used to make
 	// changes during lease renewals. Errors returned from here are sent to
-	// OnError.
+	// OnError. When nil, the lease is renewed automatically according to
+	// RenewalPolicy.
 	OnRenewLease func(subscription *Sub)
 
+	// Controls automatic renewal. DefaultRenewalPolicy is used when nil.
+	RenewalPolicy *RenewalPolicy
+
+	// The fraction of the lease after which it is renewed, between 0 and 1.
+	// 0.75 is used when zero.
+	RenewalFraction float64
+
+	// When non-zero, the lease is renewed this long before it expires instead
+	// of after RenewalFraction of it.
+	RenewalMargin time.Duration
+
+	// The lease assumed when the hub omits hub.lease_seconds or sends 0. A
+	// day is used when zero.
+	DefaultLease time.Duration
+
+	// The shortest lease asked for when non-zero. Subscriptions and renewals
+	// asking for a shorter or the hub's default lease ask for MinLease
+	// instead. Hubs may still grant shorter leases, which are renewed in
+	// time like any other.
+	MinLease time.Duration
+
+	// Limits the leases granted by the hub when non-zero. Longer leases are
+	// shortened to MaxLease. Use it to renew often with hubs granting huge
+	// leases.
+	MaxLease time.Duration
+
+	// Called when automatic renewal failed and the lease lapsed. err is the
+	// error of the last renewal attempt.
+	OnLeaseExpired func(subscription *Sub, err error)
+
+	// Called whenever the state changes, like when the hub verifies the
+	// subscription. It is called synchronously and shouldn't block.
+	OnStateChange func(old, new State)
+
 	// The client which is used to make requests to the hub.
 	Client *http.Client
 
@@ -50,15 +129,82 @@ type Sub struct {
 	// the real server.
 	Secret []byte
 
-	// The current state of the client.
-	State State
+	// When set, Secret is rotated with RotateSecret whenever an active
+	// subscription is renewed.
+	RotateSecretOnRenew bool
+
+	// How long messages signed with the previous secret are accepted after
+	// the hub verified a rotated secret. One hour is used when zero.
+	SecretGracePeriod time.Duration
+
+	// Accept messages without signatures from hubs which don't support
+	// secrets. It only applies while the subscription has no Secret, which
+	// isn't generated when subscribing if this is set. Signatures are ignored
+	// without a secret. When not set, messages without a valid signature are
+	// never passed on.
+	AllowUnsigned bool
+
+	// The weakest algorithm accepted in the X-Hub-Signature header of
+	// messages. Messages signed with weaker algorithms are rejected with an
+	// UnsupportedAlgorithmError. Every algorithm is accepted by default.
+	MinSignatureAlgorithm SignatureAlgorithm
+
+	// When set, the subscription is saved in Store whenever it changes so it
+	// can be restored with Sub.Restore() after a restart.
+	Store Store
 
-	// The time at which the lease will expire.
-	LeaseExpiry time.Time
+	// The key the subscription is saved at in Store. The callback url is used
+	// when empty.
+	StoreKey string
 
-	// A channel to cancel any pending renewals.
+	// The source of time for leases and renewals. SystemClock is used when
+	// nil.
+	Clock Clock
+
+	// How long a request to the hub waits for verification. Verifications
+	// arriving later are rejected with ErrIntentExpired. One hour is used when
+	// zero.
+	PendingTimeout time.Duration
+
+	// When set, Close cancels the subscription with the hub.
+	UnsubscribeOnClose bool
+
+	// A channel which is closed to cancel any pending renewals.
 	cancelRenew chan struct{}
 
+	// The timer which starts the next renewal.
+	renewTimer Timer
+
+	// Guards cancelRenew and renewTimer.
+	renewLock sync.Mutex
+
+	// The validators of the last fetch of the topic, see fetch.go.
+	fetchLock         sync.Mutex
+	topicETag         string
+	topicLastModified string
+
+	// The state of the lifecycle, see lifecycle.go.
+	lifecycleLock sync.Mutex
+	closing       bool
+	inflight      sync.WaitGroup
+	done          chan struct{}
+	closeErr      error
+
+	// The current state of the client and the time at which the lease will
+	// expire, guarded by stateLock. See State() and LeaseExpiry().
+	state       State
+	leaseExpiry time.Time
+	stateLock   sync.Mutex
+
+	// The request waiting for verification by the hub, guarded by stateLock.
+	pending *pendingRequest
+
+	// The secret replaced by RotateSecret and when it stops being accepted,
+	// guarded by stateLock. The expiry is zero until the hub verified the new
+	// secret.
+	previousSecret       []byte
+	previousSecretExpiry time.Time
+
 	// A mutex which ensures that multiple callback requests don't leave the
 	// response handler in an inconsistent state.
 	requestLock sync.Mutex
@@ -1,73 +1,92 @@
 package sub
 
 import (
+	"context"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
-	"time"
 )
 
-// Tries to cancel automatic renewal of the subscription. If the renewal was
-// sucessfully cancelled, returns true. The renewal can fail when there is no
-// renewal to cancel or a renewal is currently in progress.
-
 // Cancels the automatic renewal of the subscription. If there was no renewal to
-// cancel, returns false.
+// cancel, returns false. The request of a renewal in progress is aborted and
+// a renewal which is retrying failures isn't retried anymore.
 func (s *Sub) CancelRenewal() bool {
+	s.renewLock.Lock()
+	defer s.renewLock.Unlock()
+
+	return s.cancelRenewalLocked()
+}
+
+// Cancels the pending renewal. The renewLock must be held.
+func (s *Sub) cancelRenewalLocked() bool {
 	if s.cancelRenew == nil {
 		// there is no automated renewal happening
 		return false
 	}
 
-	s.cancelRenew <- struct{}{}
+	s.renewTimer.Stop()
+	close(s.cancelRenew)
+
+	// signal that there is no automatic renewal
+	s.cancelRenew = nil
+	s.renewTimer = nil
+
 	return true
 }
 
-// Schedule a callback to run when the lease is close to expiration.
+// Schedule a renewal to run when the lease is close to expiration. Any
+// renewal which is already scheduled is cancelled. Nothing is scheduled once
+// the subscription is closing.
 func (s *Sub) scheduleRenewal() {
-	numTimeToExpiry := int64(float64(s.LeaseExpiry.Sub(time.Now())) * 0.75)
-	if numTimeToExpiry < 0 {
-		numTimeToExpiry = 0
+	s.renewLock.Lock()
+	defer s.renewLock.Unlock()
+
+	s.cancelRenewalLocked()
+	if s.isClosing() {
+		return
 	}
 
+	clock := s.clock()
+	delay := s.renewalDelay(clock.Now())
+
 	// add channel to cancel renewals and signal that there is something to
 	// cancel
-	s.cancelRenew = make(chan struct{})
+	cancel := make(chan struct{})
+	s.cancelRenew = cancel
 
-	timer := time.NewTimer(time.Duration(numTimeToExpiry))
-	go func() {
-		select {
-		case <-timer.C:
-			// handle update, renew lease
-			s.OnRenewLease(s)
-
-		case <-s.cancelRenew:
-			// remove the channel to signal that there is no automatic renewal
-			s.cancelRenew = nil
-
-			// stop the timer so it doesn't leak
-			timer.Stop()
+	s.renewTimer = clock.AfterFunc(delay, func() {
+		if !s.track() {
+			// closed while the timer fired
 			return
 		}
-	}()
+		defer s.untrack()
+
+		// handle update, renew lease
+		if s.OnRenewLease != nil {
+			s.OnRenewLease(s)
+		} else {
+			s.renew(cancel)
+		}
+	})
 }
 
-// A helper function to send requests to the hub. It populates the hub.callback
+// A helper function to send requests to a hub. It populates the hub.callback
 // and hub.topic fields then builds and sends a request with the encoded values
 // and appropriate content type. If the request returns a non-202 status code,
-// an RequestError is returned.
-func (s *Sub) sendHubReq(values url.Values) error {
+// an ResponseError is returned.
+func (s *Sub) sendHubReq(ctx context.Context, hub *url.URL, values url.Values) error {
 	// identifying information
 	values.Set("hub.callback", s.Callback.String())
 	values.Set("hub.topic", s.Topic.String())
 
 	// encode request
 	bodyReader := strings.NewReader(values.Encode())
-	req, err := http.NewRequest(http.MethodPost, s.Hub.String(), bodyReader)
+	req, err := http.NewRequest(http.MethodPost, hub.String(), bodyReader)
 	if err != nil {
 		return err
 	}
+	req = req.WithContext(ctx)
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
@@ -76,6 +95,7 @@ func (s *Sub) sendHubReq(values url.Values) error {
 	if err != nil {
 		return err
 	}
+	resp.Body.Close()
 
 	// check for application errors
 	if resp.StatusCode != http.StatusAccepted {
@@ -93,7 +113,18 @@ const maxSecretLen = 200 - 1
 // Sends a subscription request to the hub suggesting a lease time of
 // leaseSeconds. The hub gets the final decision on the lease time.
 func (s *Sub) SubscribeWithLease(leaseSeconds int) error {
-	s.State = Requested
+	return s.SubscribeWithLeaseContext(context.Background(), leaseSeconds)
+}
+
+// Like SubscribeWithLease but the requests to the hubs are cancelled when ctx
+// is done.
+func (s *Sub) SubscribeWithLeaseContext(ctx context.Context, leaseSeconds int) error {
+	if s.RotateSecretOnRenew && s.State() == Subscribed {
+		s.RotateSecret()
+	}
+
+	leaseSeconds = s.requestedLease(leaseSeconds)
+	s.request(subscribeMode, leaseSeconds)
 
 	values := url.Values{}
 
@@ -105,16 +136,92 @@ func (s *Sub) SubscribeWithLease(leaseSeconds int) error {
 		values.Set("hub.lease_seconds", strconv.Itoa(leaseSeconds))
 	}
 
-	// generate secret
-	if len(s.Secret) == 0 {
-		s.Secret = RandAlphanumBytes(maxSecretLen)
+	// add secret, generating one if needed
+	if secret := s.secretForRequest(); len(secret) > 0 {
+		values.Set("hub.secret", string(secret))
 	}
 
-	// add secret
-	values.Set("hub.secret", string(s.Secret))
+	// the secret must be persisted before the hub starts using it
+	s.save()
 
 	// send request
-	return s.sendHubReq(values)
+	err := s.sendToAnyHub(ctx, values)
+	if err != nil {
+		return err
+	}
+
+	// remember which hub accepted the request
+	s.save()
+	return nil
+}
+
+// Returns the hubs to try in order, starting with s.Hub.
+func (s *Sub) candidateHubs() []*url.URL {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	hubs := []*url.URL{}
+	if s.Hub != nil {
+		hubs = append(hubs, s.Hub)
+	}
+
+	for _, hub := range s.Hubs {
+		if s.Hub != nil && hub.String() == s.Hub.String() {
+			continue
+		}
+
+		hubs = append(hubs, hub)
+	}
+
+	return hubs
+}
+
+// Sends a request to s.Hub, failing over to the other hubs in s.Hubs. s.Hub is
+// updated to the hub which accepted the request. If every hub fails, the error
+// from the last hub is returned.
+func (s *Sub) sendToAnyHub(ctx context.Context, values url.Values) error {
+	hubs := s.candidateHubs()
+	if len(hubs) == 0 {
+		return ErrNoHub
+	}
+
+	var err error
+	for _, hub := range hubs {
+		err = s.sendHubReq(ctx, hub, values)
+		if err == nil {
+			s.useHub(hub)
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			// the other hubs would fail the same way
+			return err
+		}
+
+		if s.OnError != nil && len(hubs) > 1 {
+			s.OnError(err)
+		}
+	}
+
+	return err
+}
+
+// Returns the hub the subscription was accepted by.
+func (s *Sub) activeHub() *url.URL {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	return s.Hub
+}
+
+// Remembers hub as the hub which accepted the subscription.
+func (s *Sub) useHub(hub *url.URL) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	if s.Hub != hub {
+		s.Hub = hub
+	}
 }
 
 // Sends a subscription request to the hub. If there is no error, the
@@ -124,13 +231,31 @@ func (s *Sub) Subscribe() error {
 	return s.SubscribeWithLease(0)
 }
 
+// Like Subscribe but the requests to the hubs are cancelled when ctx is done.
+func (s *Sub) SubscribeContext(ctx context.Context) error {
+	return s.SubscribeWithLeaseContext(ctx, 0)
+}
+
 // Sends an un-subscription request to the hub. If no error is returned, the
 // request completed sucessfully. State is only changed after the callback
 // server verifies.
 func (s *Sub) Unsubscribe() error {
-	s.State = Requested
+	return s.UnsubscribeContext(context.Background())
+}
+
+// Like Unsubscribe but the request to the hub is cancelled when ctx is done.
+func (s *Sub) UnsubscribeContext(ctx context.Context) error {
+	hub := s.activeHub()
+	if hub == nil {
+		return ErrNoHub
+	}
+
+	s.request(unsubscribeMode, 0)
+	s.save()
 
 	values := url.Values{}
 	values.Set("hub.mode", unsubscribeMode)
-	return s.sendHubReq(values)
+
+	// the subscription only exists on the hub which accepted it
+	return s.sendHubReq(ctx, hub, values)
 }
@@ -1,6 +1,7 @@
 package sub
 
 import (
+	"context"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -130,37 +131,234 @@ func TestUnsubscribe(t *testing.T) {
 	}
 }
 
+func TestSubscribeUnsigned(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if _, ok := r.PostForm["hub.secret"]; ok {
+			t.Error("No secret should be sent")
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	subscription := &Sub{
+		Hub:           MustParseUrl(ts.URL),
+		Topic:         MustParseUrl("https://example.com/feed.xml"),
+		Callback:      MustParseUrl("https://my-server.com/subscriber"),
+		Client:        http.DefaultClient,
+		AllowUnsigned: true,
+	}
+
+	err := subscription.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(subscription.Secret) != 0 {
+		t.Error("No secret should be generated")
+	}
+}
+
+func TestSubscribeFailover(t *testing.T) {
+	dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer dead.Close()
+
+	alive := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer alive.Close()
+
+	errs := 0
+	subscription := &Sub{
+		Hub:      MustParseUrl(dead.URL),
+		Hubs:     []*url.URL{MustParseUrl(dead.URL), MustParseUrl(alive.URL)},
+		Topic:    MustParseUrl("https://example.com/feed.xml"),
+		Callback: MustParseUrl("https://my-server.com/subscriber"),
+		Client:   http.DefaultClient,
+		OnError:  func(err error) { errs++ },
+	}
+
+	err := subscription.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if subscription.Hub.String() != alive.URL {
+		t.Error("Expected to fail over to", alive.URL, "got", subscription.Hub)
+	}
+
+	if errs != 1 {
+		t.Error("Expected the failed hub to be reported, got", errs, "errors")
+	}
+}
+
+func TestSubscribeFailoverWhileSaving(t *testing.T) {
+	dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer dead.Close()
+
+	alive := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer alive.Close()
+
+	subscription := &Sub{
+		Hub:      MustParseUrl(dead.URL),
+		Hubs:     []*url.URL{MustParseUrl(dead.URL), MustParseUrl(alive.URL)},
+		Topic:    MustParseUrl("https://example.com/feed.xml"),
+		Callback: MustParseUrl("https://my-server.com/subscriber"),
+		Client:   http.DefaultClient,
+	}
+
+	// records are taken while messages and verifications are handled
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		for i := 0; i < 100; i++ {
+			subscription.Record()
+		}
+	}()
+
+	for i := 0; i < 3; i++ {
+		err := subscription.Subscribe()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+
+	if record := subscription.Record(); record.Hub != alive.URL {
+		t.Error("Expected to fail over to", alive.URL, "got", record.Hub)
+	}
+}
+
+func TestSubscribeMinLease(t *testing.T) {
+	leases := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		leases <- r.PostForm.Get("hub.lease_seconds")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	subscription := &Sub{
+		Hub:      MustParseUrl(ts.URL),
+		Topic:    MustParseUrl("https://example.com/feed.xml"),
+		Callback: MustParseUrl("https://my-server.com/subscriber"),
+		Client:   http.DefaultClient,
+		MinLease: time.Hour,
+	}
+
+	tests := []struct {
+		requested int
+		expected  string
+	}{
+		{requested: 0, expected: "3600"},
+		{requested: 60, expected: "3600"},
+		{requested: 7200, expected: "7200"},
+	}
+
+	for _, test := range tests {
+		err := subscription.SubscribeWithLease(test.requested)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if lease := <-leases; lease != test.expected {
+			t.Error("Requested", test.requested, "expected", test.expected, "got", lease)
+		}
+	}
+}
+
+func TestSubscribeWithoutHub(t *testing.T) {
+	subscription := &Sub{
+		Topic:    MustParseUrl("https://example.com/feed.xml"),
+		Callback: MustParseUrl("https://my-server.com/subscriber"),
+	}
+
+	err := subscription.Subscribe()
+	if err != ErrNoHub {
+		t.Error("Expected ErrNoHub, got", err)
+	}
+}
+
+func TestSubscribeContextCancelled(t *testing.T) {
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// hang until the test is over
+		<-release
+	})
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+	defer close(release)
+
+	subscription := &Sub{
+		Hub:      MustParseUrl(ts.URL),
+		Topic:    MustParseUrl("https://example.com/feed.xml"),
+		Callback: MustParseUrl("https://my-server.com/subscriber"),
+		Client:   http.DefaultClient,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := subscription.SubscribeContext(ctx)
+	if err == nil {
+		t.Fatal("Expected the subscription to be cancelled")
+	}
+
+	if ctx.Err() != context.DeadlineExceeded {
+		t.Error("Expected the deadline to be exceeded", ctx.Err())
+	}
+}
+
 func TestRenewal(t *testing.T) {
 	// initialize
-	waitChan := make(chan struct{})
+	clock := newFakeClock()
+	renewed := false
 	sub := &Sub{
-		cancelRenew: make(chan struct{}),
-		LeaseExpiry: time.Now(), // some time in the future/present so renewal will run
+		Clock:       clock,
+		leaseExpiry: clock.Now().Add(100 * time.Hour),
 	}
 	sub.OnRenewLease = func(s *Sub) {
 		if s != sub {
 			t.Error("The sub is invalid")
 		}
 
-		waitChan <- struct{}{}
+		renewed = true
 	}
 
-	// ensure that OnRenewLease is called
+	// ensure that OnRenewLease is called at 75% of the lease
 	sub.scheduleRenewal()
-	<-waitChan
+
+	clock.Advance(74 * time.Hour)
+	if renewed {
+		t.Error("The lease was renewed too early")
+	}
+
+	clock.Advance(time.Hour)
+	if !renewed {
+		t.Error("The lease wasn't renewed")
+	}
 }
 
 func TestCancelRenewal(t *testing.T) {
 	// initialize
+	clock := newFakeClock()
 	sub := &Sub{
-		cancelRenew: make(chan struct{}),
+		Clock: clock,
 		OnRenewLease: func(s *Sub) {
 			t.Error("The lease shouldn't have been renewed.")
 		},
-
-		// enough time to to schedule the renewal and cancel but not too
-		// long to verify that OnRenewLease isn't called.
-		LeaseExpiry: time.Now().Add(500 * time.Millisecond),
+		leaseExpiry: clock.Now().Add(time.Hour),
 	}
 
 	// ensure that when we cancel, it's sucessful and OnRenewLease isn't fired.
@@ -170,5 +368,9 @@ func TestCancelRenewal(t *testing.T) {
 		t.Error("Failed to cancel lease")
 	}
 
-	time.Sleep(500 * time.Millisecond)
+	if sub.CancelRenewal() {
+		t.Error("There should be nothing left to cancel")
+	}
+
+	clock.Advance(time.Hour)
 }
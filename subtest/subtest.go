@@ -0,0 +1,275 @@
+/*
+This package provides an in-memory hub for testing code built on the sub
+package. The hub accepts subscriptions from real subscriptions, verifies them
+against their callbacks and lets tests publish content, deny subscriptions,
+expire leases and inspect the requests it received.
+
+	h := subtest.NewHub()
+	defer h.Close()
+
+	s := sub.New()
+	s.Hub = h.URL
+	...
+	s.Subscribe()
+	h.Wait()
+
+	h.Publish(s.Topic.String(), "application/atom+xml", feed)
+*/
+package subtest
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/0xcaff/sub"
+	"github.com/0xcaff/sub/hub"
+)
+
+// A sub.Clock which only moves when advanced. Share it between the hub and
+// subscriptions to simulate lease churn without waiting. Timers fire on the
+// goroutine calling Advance.
+type Clock struct {
+	lock   sync.Mutex
+	now    time.Time
+	timers []*timer
+}
+
+type timer struct {
+	clock *Clock
+	at    time.Time
+	f     func()
+	done  bool
+}
+
+// Returns a clock starting at now.
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+func (c *Clock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.now
+}
+
+func (c *Clock) AfterFunc(d time.Duration, f func()) sub.Timer {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	t := &timer{clock: c, at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Moves the clock forward by d. Timers which become due are fired in order,
+// with the clock set to the time each was due at.
+func (c *Clock) Advance(d time.Duration) {
+	c.lock.Lock()
+	target := c.now.Add(d)
+
+	for {
+		next := c.nextTimer(target)
+		if next == nil {
+			break
+		}
+
+		next.done = true
+		if next.at.After(c.now) {
+			c.now = next.at
+		}
+
+		// the timer may schedule more timers
+		c.lock.Unlock()
+		next.f()
+		c.lock.Lock()
+	}
+
+	c.now = target
+	c.lock.Unlock()
+}
+
+// Returns the earliest pending timer due by target. The lock must be held.
+func (c *Clock) nextTimer(target time.Time) *timer {
+	var next *timer
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if t.done {
+			continue
+		}
+		pending = append(pending, t)
+
+		if !t.at.After(target) && (next == nil || t.at.Before(next.at)) {
+			next = t
+		}
+	}
+	c.timers = pending
+
+	return next
+}
+
+func (t *timer) Stop() bool {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+
+	wasPending := !t.done
+	t.done = true
+	return wasPending
+}
+
+// A subscription or publish request received by the hub.
+type Request struct {
+	Mode     string
+	Topic    string
+	Callback string
+	Secret   string
+
+	// The lease the subscriber asked for. Zero when none was requested.
+	LeaseSeconds int
+
+	// The topics of a publish request.
+	URLs []string
+}
+
+// A hub served by a local httptest.Server.
+type Hub struct {
+	// The url subscriptions should use as their hub.
+	URL *url.URL
+
+	// The hub handling the requests. It can be configured further, like
+	// changing the lease it grants.
+	Hub *hub.Hub
+
+	// The clock the hub uses for leases. Give it to subscriptions too, so
+	// their renewals follow the hub.
+	Clock *Clock
+
+	// The server the hub is served by.
+	Server *httptest.Server
+
+	lock     sync.Mutex
+	requests []Request
+	denied   map[string]string
+	errs     []error
+}
+
+// Starts a new hub. It must be closed with Close.
+func NewHub() *Hub {
+	h := &Hub{
+		Hub:    hub.New(),
+		Clock:  NewClock(time.Now()),
+		denied: map[string]string{},
+	}
+
+	h.Hub.Now = h.Clock.Now
+	h.Hub.Validate = h.validate
+	h.Hub.OnError = h.recordError
+
+	h.Server = httptest.NewServer(http.HandlerFunc(h.serveHTTP))
+	h.URL, _ = url.Parse(h.Server.URL)
+	h.Hub.URL = h.URL
+
+	return h
+}
+
+// Records the request then hands it to the hub.
+func (h *Hub) serveHTTP(rw http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err == nil {
+		lease, _ := strconv.Atoi(r.PostForm.Get("hub.lease_seconds"))
+
+		h.lock.Lock()
+		h.requests = append(h.requests, Request{
+			Mode:         r.PostForm.Get("hub.mode"),
+			Topic:        r.PostForm.Get("hub.topic"),
+			Callback:     r.PostForm.Get("hub.callback"),
+			Secret:       r.PostForm.Get("hub.secret"),
+			LeaseSeconds: lease,
+			URLs:         r.PostForm["hub.url"],
+		})
+		h.lock.Unlock()
+	}
+
+	h.Hub.ServeHTTP(rw, r)
+}
+
+func (h *Hub) validate(topic, callback string) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	reason, ok := h.denied[topic]
+	if !ok {
+		return nil
+	}
+
+	return errors.New(reason)
+}
+
+func (h *Hub) recordError(err error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.errs = append(h.errs, err)
+}
+
+// Denies future subscriptions to topic with reason.
+func (h *Hub) Deny(topic, reason string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.denied[topic] = reason
+}
+
+// Sends content to every subscriber of topic, waiting for the deliveries to
+// finish.
+func (h *Hub) Publish(topic, contentType string, body []byte) error {
+	return h.Hub.Publish(topic, contentType, body)
+}
+
+// Ends every subscription to topic without telling the subscribers, as if
+// their leases lapsed.
+func (h *Hub) Expire(topic string) {
+	h.Hub.Expire(topic)
+}
+
+// Returns the callbacks with active subscriptions to topic.
+func (h *Hub) Subscribers(topic string) []string {
+	callbacks := []string{}
+	for _, subscription := range h.Hub.Subscriptions(topic) {
+		callbacks = append(callbacks, subscription.Callback)
+	}
+
+	return callbacks
+}
+
+// Returns every request the hub received, oldest first.
+func (h *Hub) Requests() []Request {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return append([]Request(nil), h.requests...)
+}
+
+// Returns the errors of failed verifications and deliveries.
+func (h *Hub) Errors() []error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return append([]error(nil), h.errs...)
+}
+
+// Waits for verifications and publishes in progress to finish.
+func (h *Hub) Wait() {
+	h.Hub.Wait()
+}
+
+// Waits for work in progress then stops the server.
+func (h *Hub) Close() {
+	h.Wait()
+	h.Server.Close()
+}
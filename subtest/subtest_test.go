@@ -0,0 +1,207 @@
+package subtest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/0xcaff/sub"
+)
+
+// Starts a subscription to topic on h which sends messages to received.
+func newSub(t *testing.T, h *Hub, topic string) (*sub.Sub, *httptest.Server, chan string) {
+	received := make(chan string, 1)
+
+	s := sub.New()
+	s.Topic, _ = url.Parse(topic)
+	s.Hub = h.URL
+	s.OnMessage = func(r *http.Request, body []byte) {
+		received <- string(body)
+	}
+
+	server := httptest.NewServer(s)
+	s.Callback, _ = url.Parse(server.URL)
+
+	return s, server, received
+}
+
+func TestSubscribePublish(t *testing.T) {
+	h := NewHub()
+	defer h.Close()
+
+	topic := "https://example.com/feed"
+	s, server, received := newSub(t, h, topic)
+	defer server.Close()
+	defer s.CancelRenewal()
+
+	err := s.SubscribeWithLease(60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Wait()
+
+	if s.State() != sub.Subscribed {
+		t.Fatal("Expected to be subscribed, got", s.State())
+	}
+
+	requests := h.Requests()
+	if len(requests) != 1 || requests[0].Mode != "subscribe" ||
+		requests[0].Topic != topic || requests[0].LeaseSeconds != 60 ||
+		requests[0].Secret != string(s.Secret) {
+		t.Error("Unexpected requests", requests)
+	}
+
+	err = h.Publish(topic, "text/plain", []byte("update"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if body := <-received; body != "update" {
+		t.Error("Received", body)
+	}
+
+	if errs := h.Errors(); len(errs) != 0 {
+		t.Error("Unexpected errors", errs)
+	}
+}
+
+func TestDenyAndExpire(t *testing.T) {
+	h := NewHub()
+	defer h.Close()
+
+	topic := "https://example.com/feed"
+	s, server, _ := newSub(t, h, topic)
+	defer server.Close()
+	defer s.CancelRenewal()
+
+	var denied error
+	s.OnError = func(err error) { denied = err }
+
+	h.Deny(topic, "go away")
+	err := s.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Wait()
+
+	if deniedErr, ok := denied.(*sub.DeniedError); !ok || deniedErr.Reason != "go away" {
+		t.Error("Expected the subscription to be denied, got", denied)
+	}
+
+	other := "https://example.com/other"
+	s2, server2, _ := newSub(t, h, other)
+	defer server2.Close()
+	defer s2.CancelRenewal()
+
+	err = s2.SubscribeWithLease(60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Wait()
+
+	if len(h.Subscribers(other)) != 1 {
+		t.Fatal("Expected a subscriber")
+	}
+
+	h.Clock.Advance(2 * time.Minute)
+	if len(h.Subscribers(other)) != 0 {
+		t.Error("The lease should have lapsed")
+	}
+
+	err = s2.SubscribeWithLease(60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Wait()
+
+	h.Expire(other)
+	if len(h.Subscribers(other)) != 0 {
+		t.Error("The lease should have been expired")
+	}
+}
+
+func TestLeaseChurn(t *testing.T) {
+	h := NewHub()
+	defer h.Close()
+
+	topic := "https://example.com/feed"
+	s, server, received := newSub(t, h, topic)
+	defer server.Close()
+	defer s.CancelRenewal()
+
+	// renewals ask for the default lease
+	h.Hub.DefaultLease = 24 * time.Hour
+	s.Clock = h.Clock
+	s.OnError = func(err error) { t.Error(err) }
+
+	err := s.SubscribeWithLease(24 * 60 * 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Wait()
+
+	// a month of daily leases, renewed at 75%
+	for hour := 0; hour < 30*24; hour++ {
+		h.Clock.Advance(time.Hour)
+		h.Wait()
+
+		if len(h.Subscribers(topic)) != 1 {
+			t.Fatal("The subscription lapsed after", hour, "hours")
+		}
+	}
+
+	subscribes := 0
+	for _, request := range h.Requests() {
+		if request.Mode == "subscribe" {
+			subscribes++
+		}
+	}
+
+	// the first subscription then a renewal every 18 hours
+	if subscribes != 1+30*24/18 {
+		t.Error("Unexpected number of subscriptions", subscribes)
+	}
+
+	err = h.Publish(topic, "text/plain", []byte("still here"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if body := <-received; body != "still here" {
+		t.Error("Received", body)
+	}
+}
+
+func TestLeaseLapsesWhileHubIsDown(t *testing.T) {
+	h := NewHub()
+	defer h.Close()
+
+	topic := "https://example.com/feed"
+	s, server, _ := newSub(t, h, topic)
+	defer server.Close()
+	defer s.CancelRenewal()
+
+	s.Clock = h.Clock
+	expired := false
+	s.OnLeaseExpired = func(s *sub.Sub, err error) { expired = true }
+
+	err := s.SubscribeWithLease(24 * 60 * 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Wait()
+
+	// renewals fail from now on and are retried until the lease lapses
+	h.Server.Close()
+	h.Clock.Advance(9 * 24 * time.Hour)
+
+	if !expired {
+		t.Error("OnLeaseExpired wasn't called")
+	}
+
+	if s.State() != sub.Unsubscribed {
+		t.Error("Expected the lease to have lapsed, got", s.State())
+	}
+}